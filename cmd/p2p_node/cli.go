@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"coursework_mimapr/internal/sched"
+	"coursework_mimapr/internal/style"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// config — параметры запуска узла из командной строки
+type config struct {
+	Mode      string        // initiator, processor или all
+	Bootstrap string        // multiaddr серверов через запятую или файл со списком
+	Style     string        // изображение-стиль
+	Input     string        // файл, папка или glob с изображениями
+	Output    string        // папка для результатов
+	Manifest  string        // YAML/JSON-файл со списком пар стиль/изображения
+	Deadline  time.Duration // сколько инициатор ждёт результаты, 0 — без ограничения
+	Report    string        // JSON-отчёт о заданиях; пусто — <output>/report.json
+	Retry     retryPolicy
+	Journal   string        // журнал заданий для возобновления; пусто — <output>/journal.jsonl, off — без журнала
+	Params    style.Params  // параметры стилизации для всех заданий; манифест может их переопределить
+	Tiles     tiling        // деление больших изображений на фрагменты
+	Verify    verification  // выполнение заданий несколькими процессорами со сверкой результатов
+	Key       string        // файл ключа узла; пусто — новый ID при каждом запуске
+	Token     string        // токен допуска к серверу в закрытом режиме
+	Encrypt   bool          // шифровать изображения и результаты ключами заданий
+	Unsigned  bool          // принимать неподписанные результаты процессоров протокола 1.0.0
+	DHT       bool          // находить процессоры через DHT, если сервер недоступен; сервер необязателен
+	MDNS      bool          // находить сервер и процессоры в локальной сети через mDNS, без bootstrap.txt
+	MDNSWait  time.Duration // сколько искать узлы в локальной сети перед началом работы
+	Peers     []string      // известные узлы DHT (multiaddr), через которые узел входит в сеть
+	Scheduler string        // стратегия выбора процессора в DHT
+}
+
+// parseFlags разбирает аргументы. Для совместимости режим можно передать
+// первым позиционным аргументом: "app processor".
+func parseFlags(args []string) (config, error) {
+	cfg := config{Mode: "initiator"}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.Mode = args[0]
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("p2p_node", flag.ContinueOnError)
+	fs.StringVar(&cfg.Mode, "mode", cfg.Mode, "режим работы: initiator, processor или all")
+	fs.StringVar(&cfg.Bootstrap, "bootstrap", "bootstrap.txt", "multiaddr серверов через запятую или файл с ними, по одному в строке; серверы пробуются по порядку, при отказе текущего узел переходит на следующий. Токены у каждого сервера свои: итоги заданий сообщаются серверу, который их назначил, и повторяются, пока он не вернётся")
+	fs.StringVar(&cfg.Key, "key", "", "файл ключа узла, чтобы ID не менялся между запусками (создаётся при первом запуске)")
+	fs.StringVar(&cfg.Token, "token", os.Getenv("ENROLL_TOKEN"), "токен допуска к серверу в закрытом режиме (по умолчанию $ENROLL_TOKEN)")
+	fs.StringVar(&cfg.Style, "style", "", "изображение-стиль")
+	fs.StringVar(&cfg.Input, "input", "", "изображение, папка или glob-шаблон с изображениями")
+	fs.StringVar(&cfg.Output, "output", "processed_images", "папка для обработанных изображений")
+	fs.StringVar(&cfg.Manifest, "manifest", "", "YAML/JSON-файл со списком пар стиль/изображения")
+	fs.DurationVar(&cfg.Deadline, "deadline", 2*time.Hour, "сколько ждать результаты всех заданий (0 — без ограничения)")
+	fs.StringVar(&cfg.Report, "report", "", "куда записать JSON-отчёт (по умолчанию <output>/report.json)")
+	fs.StringVar(&cfg.Journal, "journal", "", "журнал заданий для возобновления (по умолчанию <output>/journal.jsonl, off — не вести)")
+	fs.IntVar(&cfg.Retry.MaxAttempts, "attempts", 3, "сколько раз отправлять задание, прежде чем считать его неудачным")
+	fs.DurationVar(&cfg.Retry.BaseDelay, "retry-delay", 5*time.Second, "пауза перед первым повтором, дальше удваивается")
+	fs.DurationVar(&cfg.Retry.JobTimeout, "job-timeout", 45*time.Minute, "сколько ждать результат одной попытки")
+	fs.IntVar(&cfg.Params.Epochs, "epochs", 0, "шагов оптимизации на изображение (0 — по умолчанию процессора)")
+	fs.Float64Var(&cfg.Params.LR, "lr", 0, "скорость обучения (0 — по умолчанию процессора)")
+	fs.Float64Var(&cfg.Params.Alpha, "alpha", 0, "вес содержимого (0 — по умолчанию процессора)")
+	fs.Float64Var(&cfg.Params.Beta, "beta", 0, "вес стиля (0 — по умолчанию процессора)")
+	fs.IntVar(&cfg.Params.Size, "size", 0, "размер стороны изображения в пикселях (0 — по умолчанию процессора)")
+	fs.BoolVar(&cfg.Params.KeepAspect, "keep-aspect", false, "сохранять пропорции: --size ограничивает длинную сторону, результат в исходном разрешении")
+	fs.IntVar(&cfg.Tiles.Size, "tile-size", 0, "делить изображения больше этого размера на фрагменты и обрабатывать их на разных процессорах (0 — не делить)")
+	fs.IntVar(&cfg.Tiles.Overlap, "tile-overlap", 64, "перекрытие соседних фрагментов в пикселях")
+	fs.IntVar(&cfg.Verify.Replicas, "replicas", 1, "сколько процессоров выполняют каждое задание; при 2 и больше результат выбирается большинством")
+	fs.Float64Var(&cfg.Verify.Similarity, "similarity", 0.85, "минимальное сходство результатов копий (0..1), при котором они считаются совпавшими")
+	fs.BoolVar(&cfg.Encrypt, "encrypt", false, "шифровать изображения и результаты: на дисках процессоров они хранятся только в зашифрованном виде")
+	fs.BoolVar(&cfg.Unsigned, "allow-unsigned", false, "принимать результаты без подписи процессора от процессоров старой версии (протокол 1.0.0); происхождение таких результатов не проверяется")
+	fs.BoolVar(&cfg.DHT, "dht", false, "находить процессоры через DHT, когда сервер недоступен. Пока сервер отвечает, процессоры назначает он; процессоры из DHT выбираются в обход сервера: отключённые и с низкой репутацией не отсеиваются, токены не резервируются")
+	fs.BoolVar(&cfg.MDNS, "mdns", false, "найти сервер и процессоры в локальной сети (mDNS) без bootstrap.txt")
+	fs.DurationVar(&cfg.MDNSWait, "mdns-wait", 5*time.Second, "сколько искать узлы в локальной сети перед началом работы")
+	peers := fs.String("peers", "", "известные узлы DHT через запятую (multiaddr с /p2p/<id>)")
+	fs.StringVar(&cfg.Scheduler, "scheduler", "least-loaded", "стратегия выбора процессора в DHT: least-loaded или round-robin")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: p2p_node [initiator|processor|all] [флаги]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("лишние аргументы: %v", fs.Args())
+	}
+
+	switch cfg.Mode {
+	case "initiator", "processor", "all":
+	default:
+		return cfg, fmt.Errorf("неизвестный режим %q", cfg.Mode)
+	}
+	if cfg.Manifest != "" && (cfg.Style != "" || cfg.Input != "") {
+		return cfg, errors.New("--manifest нельзя сочетать с --style и --input")
+	}
+	if (cfg.Style == "") != (cfg.Input == "") {
+		return cfg, errors.New("--style и --input указываются вместе")
+	}
+	if cfg.Retry.MaxAttempts < 1 || cfg.Retry.JobTimeout <= 0 {
+		return cfg, errors.New("--attempts и --job-timeout должны быть положительными")
+	}
+	if err := cfg.Params.Validate(); err != nil {
+		return cfg, err
+	}
+	if cfg.Tiles.Size < 0 || cfg.Tiles.Overlap < 0 || (cfg.Tiles.Size > 0 && cfg.Tiles.Overlap*2 >= cfg.Tiles.Size) {
+		return cfg, errors.New("--tile-overlap должен быть меньше половины --tile-size")
+	}
+	if cfg.Verify.Replicas < 1 || cfg.Verify.Similarity < 0 || cfg.Verify.Similarity > 1 {
+		return cfg, errors.New("--replicas должен быть положительным, --similarity — от 0 до 1")
+	}
+	if cfg.MDNS && cfg.DHT {
+		return cfg, errors.New("--mdns нельзя сочетать с --dht: с --mdns узел сам входит в DHT через узлы локальной сети")
+	}
+	if cfg.MDNSWait <= 0 {
+		return cfg, errors.New("--mdns-wait должен быть положительным")
+	}
+	if *peers != "" {
+		if !cfg.DHT {
+			return cfg, errors.New("--peers используется только с --dht")
+		}
+		for _, addr := range strings.Split(*peers, ",") {
+			cfg.Peers = append(cfg.Peers, strings.TrimSpace(addr))
+		}
+	}
+	if _, err := sched.New(cfg.Scheduler); err != nil {
+		return cfg, err
+	}
+	if cfg.Report == "" {
+		cfg.Report = filepath.Join(cfg.Output, "report.json")
+	}
+	if cfg.Journal == "" {
+		cfg.Journal = filepath.Join(cfg.Output, "journal.jsonl")
+	}
+	return cfg, nil
+}
+
+// interactive сообщает, что задания не заданы флагами и их нужно спросить у пользователя
+func (c config) interactive() bool {
+	return c.Manifest == "" && c.Style == "" && c.Input == ""
+}
+
+// pairs возвращает пары стиль/изображения из манифеста, флагов или интерактивного ввода
+func (c config) pairs() ([]pair, error) {
+	switch {
+	case c.Manifest != "":
+		return loadManifest(c.Manifest, c.Output, c.Params)
+	case !c.interactive():
+		return []pair{{Style: c.Style, Input: c.Input, Output: c.Output, Params: c.Params}}, nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("\n🖌 Введите путь к изображению-стилю: ")
+	stylePath, _ := reader.ReadString('\n')
+	fmt.Print("\n📂 Введите путь к папке с изображениями для стилизации: ")
+	dirPath, _ := reader.ReadString('\n')
+	return []pair{{
+		Style:  strings.TrimSpace(stylePath),
+		Input:  strings.TrimSpace(dirPath),
+		Output: c.Output,
+		Params: c.Params,
+	}}, nil
+}
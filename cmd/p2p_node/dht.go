@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"fmt"
+	"log"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// joinDHT подключает узел к DHT через bootstrap-серверы и узлы из --peers. Если какой-то сервер
+// доступен, узел регистрируется на нём как обычно (в закрытом режиме иначе сервер отключит узел)
+// и возвращает список серверов; недоступные серверы — не ошибка, тогда servers — nil
+// и узел работает без них.
+func joinDHT(h host.Host, cfg config) (servers *p2p.Servers, kad *dht.IpfsDHT, err error) {
+	var peers []peerstore.AddrInfo
+	if list, err := loadBootstrap(cfg.Bootstrap); err != nil {
+		log.Println("⚠️ Сервер не используется:", err)
+	} else {
+		servers = p2p.NewServers(h, list, cfg.Mode, cfg.Token)
+		if _, err := servers.Connect(); err != nil {
+			log.Println("⚠️ Серверы недоступны, работаем без них:", err)
+			servers = nil
+		}
+		peers = append(peers, list...)
+	}
+	for _, addr := range cfg.Peers {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return servers, nil, fmt.Errorf("неверный адрес узла %q: %w", addr, err)
+		}
+		info, err := peerstore.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return servers, nil, fmt.Errorf("адрес узла %q без /p2p/<id>: %w", addr, err)
+		}
+		peers = append(peers, *info)
+	}
+	if len(peers) == 0 {
+		log.Println("⚠️ Нет известных узлов DHT: ждём, пока к узлу подключатся другие (--peers)")
+	}
+	kad, err = p2p.StartDHT(context.Background(), h, peers)
+	return servers, kad, err
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/style"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// stylesDir — куда сохраняются признаки стилей, извлечённые инициатором
+const stylesDir = "extracted_styles"
+
+// extractedStyle — признаки одного изображения-стиля
+type extractedStyle struct {
+	File string // файл признаков стиля
+	Hash string // SHA-256 файла File
+}
+
+// runInitiator запускает все изображения пар на стилизацию. Изображения, уже выполненные
+// с тем же стилем по журналу journal, пропускаются; признаки стиля извлекаются один раз
+// и только если для него остались задания. Каждое задание повторяется по policy,
+// пока не будет выполнено или не истечёт ctx. Изображения больше tiles.Size делятся на фрагменты.
+// Если v включает проверку, каждое задание (или фрагмент) выполняют несколько процессоров.
+// encrypt — изображения и результаты передаются зашифрованными ключами задания.
+// Процессоры назначает сервер, а если он недоступен и задан disc — они выбираются среди найденных в DHT.
+// false — какой-то из входов не удалось прочитать или какой-то стиль не удалось извлечь.
+func runInitiator(ctx context.Context, h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, journal *p2p.Journal, pairs []pair, policy retryPolicy, tiles tiling, v verification, encrypt bool) bool {
+	ok := true
+	type pending struct {
+		job    p2p.Job
+		style  string
+		params style.Params
+	}
+	var queue []pending
+	skipped := 0
+	for _, pr := range pairs {
+		images, err := expandInput(pr.Input)
+		if err != nil {
+			log.Printf("❌ Ошибка чтения %s: %v\n", pr.Input, err)
+			ok = false
+			continue
+		}
+		styleImageHash, err := style.HashFile(pr.Style)
+		if err != nil {
+			log.Printf("❌ Ошибка чтения стиля %s: %v\n", pr.Style, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("🗂 %s: %d изображений ➜ %s %s\n", filepath.Base(pr.Style), len(images), pr.Output, pr.Params)
+		for _, imagePath := range images {
+			inputHash, err := style.HashFile(imagePath)
+			if err != nil {
+				log.Printf("❌ Ошибка чтения %s: %v\n", imagePath, err)
+				ok = false
+				continue
+			}
+			job := jobs.Add(imagePath, pr.Style, pr.Output)
+			if done, found := journal.Completed(inputHash, styleImageHash, pr.Params.String(), pr.Output); found {
+				jobs.Done(job.ID, done.Result, style.Result{})
+				skipped++
+				continue
+			}
+			journal.Track(*job, inputHash, styleImageHash, pr.Params.String())
+			queue = append(queue, pending{job: *job, style: pr.Style, params: pr.Params})
+		}
+	}
+	if skipped > 0 {
+		fmt.Printf("⏭ Пропущено уже выполненных по журналу: %d\n", skipped)
+	}
+
+	// Для каждого изображения запрашиваем получателя и отправляем изображение со ссылкой на хэш стиля.
+	// Сам стиль загружается, только если у получателя его ещё нет.
+	// Если стиль не удалось извлечь, его задания завершаются с ошибкой, остальные пары выполняются.
+	styles := make(map[string]extractedStyle)
+	styleErrs := make(map[string]error)
+	usedNames := make(map[string]bool)
+	sendSlots := make(chan struct{}, maxParallelSends)
+	started := 0
+	for _, p := range queue {
+		st, extracted := styles[p.style]
+		if !extracted && styleErrs[p.style] == nil {
+			var err error
+			if st, err = extractStyle(p.style, usedNames); err != nil {
+				log.Printf("❌ Ошибка извлечения стиля %s: %v\n", p.style, err)
+				styleErrs[p.style] = err
+				ok = false
+			} else {
+				styles[p.style] = st
+			}
+		}
+		if err := styleErrs[p.style]; err != nil {
+			jobs.Fail(p.job.ID, "не удалось извлечь стиль: "+err.Error())
+			continue
+		}
+		started++
+		req := p2p.ImageRequest{JobID: p.job.ID, ImagePath: p.job.InputPath, StylePath: st.File, StyleHash: st.Hash, Params: p.params, Encrypt: encrypt}
+		if tiles.needed(p.job.InputPath) {
+			go runTiled(ctx, h, servers, disc, jobs, p.job, req, tiles, v, policy, sendSlots)
+			continue
+		}
+		startJob(ctx, h, servers, disc, jobs, p.job, req, v, policy, sendSlots)
+	}
+	fmt.Printf("✅ Запущено заданий: %d (попыток на задание до %d). Ожидайте обработанные результаты.\n", started, policy.MaxAttempts)
+	return ok
+}
+
+// batchContext ограничивает работу с заданиями сроком deadline от started (0 — без ограничения)
+func batchContext(started time.Time, deadline time.Duration) (context.Context, context.CancelFunc) {
+	if deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), started.Add(deadline))
+}
+
+// reportWait — сколько после завершения заданий ждать серверы, которым не доставлены итоги
+const reportWait = 2 * time.Minute
+
+// awaitResults ждёт результаты всех заданий до отмены ctx (общий срок deadline),
+// выводит сводку и пишет отчёт в reportPath. Задания без результата к сроку считаются
+// неудачными, их резерв возвращается. Возвращает false, если хотя бы одно задание не выполнено.
+func awaitResults(ctx context.Context, h host.Host, servers *p2p.Servers, jobs *p2p.JobTable, started time.Time, deadline time.Duration, reportPath string) bool {
+	if err := jobs.Wait(ctx); err != nil {
+		expired := jobs.Expire(fmt.Sprintf("результат не получен за %s", deadline))
+		log.Printf("⏰ Срок ожидания истёк, не завершено заданий: %d\n", len(expired))
+		for _, job := range expired {
+			if job.Peer != "" {
+				refund(h, servers, job.Server, job.ID, job.Error)
+			}
+		}
+	}
+	if servers != nil {
+		// Итоги для недоступных серверов повторяются в фоне: даём им время вернуться
+		reportCtx, cancel := context.WithTimeout(context.Background(), reportWait)
+		if n := servers.WaitReports(reportCtx); n > 0 {
+			log.Printf("⚠️ Не доставлено итогов заданий: %d — их серверы так и не ответили, резервы токенов остаются на них\n", n)
+		}
+		cancel()
+	}
+
+	r := newReport(started, jobs.List())
+	fmt.Println()
+	printSummary(os.Stdout, r)
+	if health := servers.Health(); len(health) > 1 {
+		fmt.Println("🩺 Серверы:")
+		for _, line := range health {
+			fmt.Println(" -", line)
+		}
+	}
+	if err := writeReport(reportPath, r); err != nil {
+		log.Println("❌ Ошибка записи отчёта:", err)
+	} else {
+		fmt.Println("📄 Отчёт сохранён в", reportPath)
+	}
+	return r.Failed == 0
+}
+
+// extractStyle извлекает признаки стиля из изображения в stylesDir/<имя>.pt
+func extractStyle(imagePath string, usedNames map[string]bool) (extractedStyle, error) {
+	stem := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	name := stem
+	for i := 2; usedNames[name]; i++ {
+		name = fmt.Sprintf("%s_%d", stem, i)
+	}
+	usedNames[name] = true
+	if err := os.MkdirAll(stylesDir, 0755); err != nil {
+		return extractedStyle{}, err
+	}
+	styleFile := filepath.Join(stylesDir, name+".pt")
+
+	fmt.Println("⏳ Извлечение признаков стиля", imagePath, "...")
+	cmd := exec.Command(style.GetPythonCommand(), style.Script, "extract-style", imagePath, styleFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return extractedStyle{}, err
+	}
+	hash, err := style.HashFile(styleFile)
+	if err != nil {
+		return extractedStyle{}, fmt.Errorf("чтение признаков стиля: %w", err)
+	}
+	fmt.Println("✅ Признаки стиля сохранены в", styleFile, "хэш", hash)
+	return extractedStyle{File: styleFile, Hash: hash}, nil
+}
+
+// maxDispatchAttempts — сколько раз просить у сервера другой процессор, если назначенный занят
+const maxDispatchAttempts = 5
+
+// errAllBusy — все назначенные сервером процессоры заняты; это не повод исключать их из задания
+var errAllBusy = errors.New("все назначенные процессоры заняты")
+
+// dispatch запрашивает у сервера (или выбирает в DHT через disc) процессор, кроме exclude,
+// и отправляет ему изображение. Ответ "busy" — повод попросить другой процессор; если снова
+// назначен уже занятый, ждём подсказанное процессором время.
+// Сервер резервирует токены при первом назначении; если изображение так и не
+// удалось передать, инициатор сообщает об ошибке, и резерв возвращается.
+func dispatch(h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, req p2p.ImageRequest, exclude []peerstore.ID) error {
+	busyPeers := make(map[peerstore.ID]time.Duration)
+	var reserved peerstore.ID // сервер, на котором сейчас зарезервированы токены задания
+	for attempt := 1; attempt <= maxDispatchAttempts; attempt++ {
+		receiverInfo, server, err := requestPeer(h, servers, disc, req, exclude)
+		if server != reserved && reserved != "" {
+			// Запрос ушёл другому серверу: резерв на прежнем больше не понадобится
+			refund(h, servers, reserved, req.JobID, "задание назначено через другой сервер")
+		}
+		reserved = server
+		if err != nil {
+			if attempt > 1 {
+				refund(h, servers, reserved, req.JobID, err.Error())
+			}
+			return err
+		}
+		receiverID := receiverInfo.ID
+		if wait, ok := busyPeers[receiverID]; ok {
+			fmt.Printf("⏳ %s всё ещё может быть занят, ждём %s\n", receiverID, wait)
+			time.Sleep(wait)
+		}
+		req.Server = reserved
+		jobs.Assign(req.JobID, receiverID, reserved)
+		h.Peerstore().AddAddrs(receiverInfo.ID, receiverInfo.Addrs, time.Hour)
+		if err := h.Connect(context.Background(), receiverInfo); err != nil {
+			refund(h, servers, reserved, req.JobID, err.Error())
+			return fmt.Errorf("подключение к получателю: %w", err)
+		}
+
+		// Отправляем изображение
+		fmt.Printf("📤 Отправляем %s ➜ %s (задание %s)...\n", filepath.Base(req.ImagePath), receiverID, req.JobID)
+		err = p2p.SendImage(h, receiverInfo, req, jobs)
+		var busy *p2p.BusyError
+		if !errors.As(err, &busy) {
+			if err != nil {
+				refund(h, servers, reserved, req.JobID, err.Error())
+			}
+			return err
+		}
+		fmt.Println("🚦", busy.Error())
+		busyPeers[receiverID] = busy.RetryAfter
+	}
+	refund(h, servers, reserved, req.JobID, errAllBusy.Error())
+	return fmt.Errorf("%w (%d попыток)", errAllBusy, maxDispatchAttempts)
+}
+
+// requestPeer назначает процессор заданию req через сервер и возвращает сервер, зарезервировавший
+// токены. Если сервера нет или ни один не отвечает и задан disc, процессор выбирается в DHT —
+// без проверки репутации на сервере и без резерва (сервер пустой).
+func requestPeer(h host.Host, servers *p2p.Servers, disc *p2p.Discovery, req p2p.ImageRequest, exclude []peerstore.ID) (peerstore.AddrInfo, peerstore.ID, error) {
+	info, server, err := p2p.RequestPeer(h, servers, req.JobID, exclude, req.Group)
+	if disc == nil || !errors.Is(err, p2p.ErrNoServer) {
+		return info, server, err
+	}
+	if servers != nil {
+		log.Printf("⚠️ Серверы недоступны (%v), процессор для задания %s выбирается в DHT без проверки репутации\n", err, req.JobID)
+	}
+	info, err = disc.RequestPeer(req, exclude)
+	return info, "", err
+}
+
+// refund сообщает серверу server, что задание не передано процессору, чтобы резерв вернулся
+// на баланс. Пустой server — процессор найден в DHT, токены не резервировались.
+func refund(h host.Host, servers *p2p.Servers, server peerstore.ID, jobID, reason string) {
+	if server == "" {
+		return
+	}
+	err := p2p.ReportJob(h, servers, server, jobID, p2p.StatusFailed, reason)
+	switch {
+	case errors.Is(err, p2p.ErrReportPending):
+		log.Printf("⏳ Сервер %s не отвечает, токены за задание %s вернутся, когда он будет доступен\n", server, jobID)
+	case err != nil:
+		log.Printf("⚠️ Не удалось вернуть токены за задание %s: %v\n", jobID, err)
+	}
+}
@@ -1,40 +1,45 @@
 package main
 
 import (
-	"bufio"
-	"context"
 	p2p "coursework_mimapr/internal/p2p"
-	"coursework_mimapr/internal/style"
+	"coursework_mimapr/internal/sched"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	libp2p "github.com/libp2p/go-libp2p"
-	network "github.com/libp2p/go-libp2p/core/network"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
 	peerstore "github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-var styleFile = "style.pt" // Файл, в котором будут признаки стиля
-
-// sentStyle отслеживает, для каких пиров уже отправлены признаки стиля.
-var sentStyle = make(map[peerstore.ID]bool)
-
 func main() {
-	// Определяем режим работы: "initiator" или "processor" (по умолчанию initiator)
-	mode := "initiator"
-	if len(os.Args) > 1 {
-		mode = os.Args[1]
+	// Режим работы и задания берутся из флагов; без флагов инициатор спрашивает стиль и папку
+	cfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal("❌ ", err)
 	}
+	mode := cfg.Mode
 	fmt.Println("Режим работы:", mode)
 
 	// Создаем P2P-узел с открытым портом
-	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	opts := []libp2p.Option{libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0")}
+	if cfg.Key != "" {
+		privKey, err := p2p.LoadOrCreateKey(cfg.Key)
+		if err != nil {
+			log.Fatal("❌ Ошибка чтения ключа узла:", err)
+		}
+		opts = append(opts, libp2p.Identity(privKey))
+	}
+	h, err := libp2p.New(opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -47,131 +52,146 @@ func main() {
 	selfInfo := peerstore.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
 	h.Peerstore().AddAddrs(selfInfo.ID, selfInfo.Addrs, time.Hour)
 
-	// Подключаемся к bootstrap-серверу
-	bootstrapAddrStr, err := os.ReadFile("bootstrap.txt")
-	if err != nil {
-		log.Fatal("❌ Ошибка чтения bootstrap.txt:", err)
-	}
-	bootstrapAddr := strings.TrimSpace(string(bootstrapAddrStr))
-	maddr, err := ma.NewMultiaddr(bootstrapAddr)
-	if err != nil {
-		log.Fatal("❌ Ошибка парсинга bootstrap-адреса:", err)
-	}
-	bootstrapInfo, err := peerstore.AddrInfoFromP2pAddr(maddr)
-	if err != nil {
-		log.Fatal("❌ Ошибка преобразования в PeerInfo:", err)
+	// Процессоры назначает bootstrap-сервер. С --dht сервер необязателен: узел входит в DHT
+	// через него (если он доступен) и узлы из --peers, а инициатор ищет процессоры в DHT сам,
+	// когда ни один сервер не отвечает.
+	// С --mdns сервер и узлы входа в DHT находятся в локальной сети без bootstrap.txt.
+	var servers *p2p.Servers
+	var kad *dht.IpfsDHT
+	stopLAN := func() {}
+	if !cfg.DHT && !cfg.MDNS && bootstrapMissing(cfg.Bootstrap) {
+		fmt.Printf("🏠 %s не найден, ищем сервер и процессоры в локальной сети (mDNS)\n", cfg.Bootstrap)
+		cfg.MDNS = true
 	}
-	err = h.Connect(context.Background(), *bootstrapInfo)
-	if err != nil {
-		log.Fatal("❌ Ошибка подключения к серверу:", err)
+	switch {
+	case cfg.DHT:
+		servers, kad, err = joinDHT(h, cfg)
+		if err != nil {
+			log.Fatal("❌ Ошибка подключения к DHT:", err)
+		}
+	case cfg.MDNS:
+		servers, kad, stopLAN, err = discoverLAN(h, cfg)
+		if err != nil {
+			log.Fatal("❌ Ошибка поиска узлов в локальной сети:", err)
+		}
+	default:
+		// Серверы из списка пробуются по порядку; если текущий перестанет отвечать,
+		// узел перейдёт на следующий
+		list, err := loadBootstrap(cfg.Bootstrap)
+		if err != nil {
+			log.Fatal("❌ ", err)
+		}
+		servers = p2p.NewServers(h, list, mode, cfg.Token)
+		if _, err := servers.Connect(); err != nil {
+			log.Fatal("❌ Ошибка подключения к серверу: ", err)
+		}
 	}
-	fmt.Println("✅ Подключен к серверу:", bootstrapInfo.ID)
 
-	// Если режим processor, регистрируем обработчики для приема стиля и изображений
-	if mode == "processor" {
-		h.SetStreamHandler("/receive-style/1.0.0", p2p.HandleReceiveStyle)
-		h.SetStreamHandler("/receive-image/1.0.0", p2p.MakeReceiveImageHandler(h))
-		fmt.Println("🔧 Режим процессора: обработчики для /receive-style/1.0.0 и /receive-image/1.0.0 зарегистрированы.")
-		// Режим процессора работает только для обработки входящих данных
-		select {}
-	} else {
-		h.SetStreamHandler("/receive-image-result/1.0.0", func(s network.Stream) {
-			defer s.Close()
-			reader := bufio.NewReader(s)
+	// Режимы processor и all принимают задания от других узлов
+	stopProcessor := func() {}
+	if mode == "processor" || mode == "all" {
+		stopProcessor = startProcessor(h, servers, kad)
+	}
 
-			header, err := reader.ReadString('\n')
+	// Режимы initiator и all отправляют свои изображения и ждут результаты.
+	// Таблица заданий инициатора: по jobID сопоставляем результаты с исходными файлами
+	ok := true
+	if mode == "initiator" || mode == "all" {
+		started := time.Now()
+		jobs := p2p.NewJobTable()
+		h.SetStreamHandler(p2p.ProtoResult, p2p.MakeReceiveResultHandler(jobs, cfg.Output))
+		h.SetStreamHandler(p2p.ProtoResultV1, p2p.MakeReceiveResultHandlerV1(jobs, cfg.Output, cfg.Unsigned))
+		pairs, err := cfg.pairs()
+		if err != nil {
+			log.Fatal("❌ ", err)
+		}
+		// Журнал позволяет после перезапуска пропустить уже выполненные изображения
+		var journal *p2p.Journal
+		if cfg.Journal != "off" {
+			journal, err = p2p.OpenJournal(cfg.Journal)
 			if err != nil {
-				log.Println("❌ Ошибка чтения результата:", err)
-				return
+				log.Fatal("❌ Ошибка открытия журнала:", err)
 			}
-			header = strings.TrimSpace(header)
-
-			if header == "ERROR" {
-				msg, _ := reader.ReadString('\n')
-				log.Println("❌ Процессор сообщил об ошибке:", strings.TrimSpace(msg))
-				return
-			}
-
-			if header == "IMAGE" {
-				timestamp := time.Now().UnixNano()
-				dir := "processed_images"
-				os.MkdirAll(dir, 0755)
-				fileName := fmt.Sprintf("%s/styled_%d.jpg", dir, timestamp)
-				file, err := os.Create(fileName)
-				if err != nil {
-					log.Println("❌ Ошибка создания файла результата:", err)
-					return
-				}
-				defer file.Close()
-				_, err = io.Copy(file, reader)
-				if err != nil {
-					log.Println("❌ Ошибка сохранения результата:", err)
-					return
-				}
-				log.Println("✅ Обработанный файл получен:", fileName)
-			}
-		})
-
+			jobs.OnChange(journal.Record)
+			fmt.Println("📒 Журнал заданий:", cfg.Journal)
+		}
+		// Пока сервер доступен, процессоры назначает он: отключённые и ненадёжные процессоры
+		// не получают заданий, токены резервируются. Из DHT процессоры выбираются, только
+		// если ни один сервер не отвечает; их репутация не проверяется, токены не резервируются
+		var disc *p2p.Discovery
+		if kad != nil {
+			strategy, _ := sched.New(cfg.Scheduler)
+			disc = p2p.NewDiscovery(h, kad, strategy)
+		}
+		ctx, cancel := batchContext(started, cfg.Deadline)
+		inputsOK := runInitiator(ctx, h, servers, disc, jobs, journal, pairs, cfg.Retry, cfg.Tiles, cfg.Verify, cfg.Encrypt)
+		ok = awaitResults(ctx, h, servers, jobs, started, cfg.Deadline, cfg.Report) && inputsOK
+		cancel()
+		journal.Close()
 	}
-	// Режим инициатора:
-	// 1. Извлекаем стиль
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("\n🖌 Введите путь к изображению-стилю: ")
-	styleImgPath, _ := reader.ReadString('\n')
-	styleImgPath = strings.TrimSpace(styleImgPath)
 
-	fmt.Println("⏳ Извлечение признаков стиля...")
-	cmd := exec.Command(style.GetPythonCommand(), "style_transfer.py", "extract-style", styleImgPath, styleFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatal("❌ Ошибка извлечения стиля:", err)
-	}
-	fmt.Println("✅ Признаки стиля сохранены в", styleFile)
+	// Процессор работает до сигнала остановки, инициатор завершается после получения результатов
+	if mode != "initiator" {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+	}
+	fmt.Println("🛑 Остановка узла...")
+	stopProcessor()
+	stopLAN()
+	if kad != nil {
+		kad.Close()
+	}
+	h.Close()
+	if !ok {
+		os.Exit(1)
+	}
+}
 
-	// 2. Запрашиваем путь к папке с изображениями для стилизации
-	fmt.Print("\n📂 Введите путь к папке с изображениями для стилизации: ")
-	dirPath, _ := reader.ReadString('\n')
-	dirPath = strings.TrimSpace(dirPath)
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		log.Fatal("❌ Ошибка чтения папки:", err)
+// bootstrapMissing сообщает, что адрес сервера должен быть в файле value, а файла нет
+func bootstrapMissing(value string) bool {
+	if strings.HasPrefix(value, "/") {
+		return false
 	}
+	_, err := os.Stat(value)
+	return os.IsNotExist(err)
+}
 
-	// Для каждого изображения запрашиваем получателя, отправляем стиль (если еще не отправлен) и само изображение
-	for _, file := range files {
-		if file.IsDir() || !style.IsImageFile(file) {
-			continue
+// loadBootstrap разбирает адреса серверов в порядке предпочтения: multiaddr через запятую
+// или путь к файлу с ними (bootstrap.txt) — по одному в строке, # — комментарий
+func loadBootstrap(value string) ([]peerstore.AddrInfo, error) {
+	var addrs []string
+	if strings.HasPrefix(value, "/") {
+		addrs = strings.Split(value, ",")
+	} else {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения %s: %w", value, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			addrs = append(addrs, line)
 		}
-		imagePath := filepath.Join(dirPath, file.Name())
+	}
 
-		receiverID, receiverAddrs := p2p.RequestPeer(h, *bootstrapInfo)
-		if receiverID == "" {
-			fmt.Println("⚠️ Нет доступных получателей для файла:", file.Name())
+	var list []peerstore.AddrInfo
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
 			continue
 		}
-		receiverInfo := peerstore.AddrInfo{
-			ID:    receiverID,
-			Addrs: receiverAddrs,
-		}
-		h.Peerstore().AddAddrs(receiverInfo.ID, receiverInfo.Addrs, time.Hour)
-
-		err = h.Connect(context.Background(), receiverInfo)
+		maddr, err := ma.NewMultiaddr(addr)
 		if err != nil {
-			log.Println("❌ Ошибка подключения к получателю для отправки результата:", err)
-			return
+			return nil, fmt.Errorf("ошибка парсинга bootstrap-адреса %q: %w", addr, err)
 		}
-		// Если стиль еще не отправлен этому получателю, отправляем его
-		if !sentStyle[receiverID] {
-			p2p.SendStyle(h, receiverInfo, styleFile)
-			sentStyle[receiverID] = true
+		info, err := peerstore.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка преобразования в PeerInfo %q: %w", addr, err)
 		}
-
-		// Отправляем изображение
-		fmt.Printf("📤 Отправляем %s ➜ %s...\n", file.Name(), receiverID)
-		p2p.SendImage(h, receiverInfo, imagePath)
+		list = append(list, *info)
 	}
-
-	fmt.Println("✅ Все изображения отправлены. Ожидайте обработанные результаты.")
-	select {}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("в %s нет адресов серверов", value)
+	}
+	return list, nil
 }
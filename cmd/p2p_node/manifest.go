@@ -0,0 +1,126 @@
+package main
+
+import (
+	"coursework_mimapr/internal/style"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pair — один стиль и изображения, которые им нужно стилизовать
+type pair struct {
+	Style  string       `yaml:"style"`
+	Input  string       `yaml:"input"`  // файл, папка или glob
+	Output string       `yaml:"output"` // папка для результатов
+	Params style.Params `yaml:"params"` // параметры стилизации; незаданные берутся из манифеста и флагов
+}
+
+// manifest — файл со списком заданий. JSON — подмножество YAML, поэтому подходят оба формата.
+// params задаёт параметры стилизации для всех заданий, params у задания — для его изображений:
+//
+//	output: processed_images
+//	params: {epochs: 200}
+//	jobs:
+//	  - style: style_image/starry_night.jpg
+//	    input: test_images
+//	  - style: style_image/scream.jpg
+//	    input: "photos/*.png"
+//	    output: processed_images/scream
+//	    params: {size: 768, beta: 100, keep_aspect: true}
+type manifest struct {
+	Output string       `yaml:"output"`
+	Params style.Params `yaml:"params"`
+	Jobs   []pair       `yaml:"jobs"`
+}
+
+// loadManifest читает манифест. Относительные пути считаются от папки манифеста.
+// Без output у задания результаты пишутся в <output>/<имя стиля>, чтобы разные
+// стили одного изображения не перезаписывали друг друга. Параметры задания дополняются
+// параметрами манифеста, а те — defaultParams из флагов.
+func loadManifest(path, defaultOutput string, defaultParams style.Params) ([]pair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("разбор манифеста %s: %w", path, err)
+	}
+	if len(m.Jobs) == 0 {
+		return nil, fmt.Errorf("в манифесте %s нет заданий", path)
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	output := defaultOutput
+	if m.Output != "" {
+		output = resolve(m.Output)
+	}
+	params := m.Params.Or(defaultParams)
+
+	pairs := make([]pair, 0, len(m.Jobs))
+	for i, p := range m.Jobs {
+		if p.Style == "" || p.Input == "" {
+			return nil, fmt.Errorf("задание %d манифеста: нужны style и input", i+1)
+		}
+		p.Style, p.Input = resolve(p.Style), resolve(p.Input)
+		if p.Output != "" {
+			p.Output = resolve(p.Output)
+		} else {
+			stem := strings.TrimSuffix(filepath.Base(p.Style), filepath.Ext(p.Style))
+			p.Output = filepath.Join(output, stem)
+		}
+		p.Params = p.Params.Or(params)
+		if err := p.Params.Validate(); err != nil {
+			return nil, fmt.Errorf("задание %d манифеста: %w", i+1, err)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// expandInput превращает файл, папку или glob-шаблон в список изображений
+func expandInput(input string) ([]string, error) {
+	if info, err := os.Stat(input); err == nil {
+		if !info.IsDir() {
+			return []string{input}, nil
+		}
+		files, err := os.ReadDir(input)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, file := range files {
+			if file.IsDir() || !style.IsImageFile(file) {
+				continue
+			}
+			paths = append(paths, filepath.Join(input, file.Name()))
+		}
+		return paths, nil
+	}
+
+	matches, err := filepath.Glob(input)
+	if err != nil {
+		return nil, fmt.Errorf("неверный шаблон %q: %w", input, err)
+	}
+	var paths []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && !info.IsDir() && style.IsImageName(m) {
+			paths = append(paths, m)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("по %q не найдено изображений", input)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"fmt"
+	"log"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	host "github.com/libp2p/go-libp2p/core/host"
+)
+
+// discoverLAN находит узлы локальной сети через mDNS в течение cfg.MDNSWait и входит через
+// них в DHT, где процессоры объявляют себя, — без bootstrap.txt. Найденные серверы становятся
+// списком серверов узла; если серверов нет или ни один не принял регистрацию, servers — nil
+// и процессоры ищутся в DHT. Поиск в локальной сети продолжается до вызова stop.
+func discoverLAN(h host.Host, cfg config) (servers *p2p.Servers, kad *dht.IpfsDHT, stop func(), err error) {
+	lan, service, err := p2p.StartMDNS(h)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Printf("🏠 Ищем узлы в локальной сети (%s)...\n", cfg.MDNSWait)
+	time.Sleep(cfg.MDNSWait)
+
+	peers := lan.Peers()
+	if schedulers := lan.Schedulers(); len(schedulers) == 0 {
+		fmt.Println("🏠 Сервер в локальной сети не найден, процессоры ищутся в DHT")
+	} else {
+		servers = p2p.NewServers(h, schedulers, cfg.Mode, cfg.Token)
+		if _, err := servers.Connect(); err != nil {
+			log.Println("⚠️ Серверы из локальной сети не приняли регистрацию, работаем без них:", err)
+			servers = nil
+		}
+	}
+	kad, err = p2p.StartDHT(context.Background(), h, peers)
+	if err != nil {
+		service.Close()
+		return servers, nil, nil, err
+	}
+	fmt.Printf("🏠 Найдено узлов в локальной сети: %d\n", len(peers))
+	return servers, kad, func() { service.Close() }, nil
+}
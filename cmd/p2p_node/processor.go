@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/style"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	host "github.com/libp2p/go-libp2p/core/host"
+)
+
+// startProcessor регистрирует обработчики приёма стиля и изображений и начинает
+// отправлять серверу heartbeat, а если узел в DHT (kad), объявляет в ней процессор.
+// nil servers — узел работает без сервера. Возвращает функцию остановки.
+func startProcessor(h host.Host, servers *p2p.Servers, kad *dht.IpfsDHT) (stop func()) {
+	// Очередь ограничивает число одновременных стилизаций (WORKERS) и ожидающих заданий (QUEUE_DEPTH).
+	// Обработчиков очереди столько, сколько воркеров удалось запустить: эту ёмкость видят сервер и DHT.
+	stylizer, workers, gpu, stopStylizer := newStylizer(envInt("WORKERS", 1))
+	queue := p2p.NewJobQueue(workers, envInt("QUEUE_DEPTH", 4))
+	styles := style.NewStore("received_styles")
+	// Границы параметров, которые инициатор может запросить для одного задания (MAX_EPOCHS, MAX_SIZE)
+	limits := style.Limits{MaxEpochs: envInt("MAX_EPOCHS", 500), MaxSize: envInt("MAX_SIZE", 1024)}
+	proc := &p2p.Processor{Host: h, Servers: servers, Styles: styles, Stylizer: stylizer, Queue: queue, Limits: limits}
+	h.SetStreamHandler(p2p.ProtoStyle, p2p.MakeReceiveStyleHandler(styles))
+	h.SetStreamHandler(p2p.ProtoStylize, p2p.MakeStylizeHandler(proc))
+	h.SetStreamHandler(p2p.ProtoImage, p2p.MakeReceiveImageHandler(proc))
+	h.SetStreamHandler(p2p.ProtoStyleV1, p2p.MakeReceiveStyleHandlerV1(styles))
+	h.SetStreamHandler(p2p.ProtoImageV1, p2p.MakeReceiveImageHandlerV1(proc))
+	stats := queue.Stats()
+	fmt.Printf("🔧 Режим процессора: обработчики для /receive-style, /receive-image (2.0.0, 1.0.0) и /stylize зарегистрированы, обработчиков %d, очередь %d, не больше %d эпох и %d px.\n", stats.Workers, stats.Depth, limits.MaxEpochs, limits.MaxSize)
+
+	// Сообщаем серверу загрузку, чтобы он назначал задания наименее занятым процессорам;
+	// инициаторам без сервера та же загрузка видна в записи процессора в DHT
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	if servers != nil {
+		p2p.StartHeartbeat(heartbeatCtx, h, servers, queue, gpu)
+	}
+	if kad != nil {
+		p2p.AdvertiseService(heartbeatCtx, h, kad, queue, gpu, limits)
+		fmt.Println("🌐 Процессор объявлен в DHT как", p2p.ServiceStyleTransfer)
+	}
+	return func() {
+		stopHeartbeat()
+		stopStylizer()
+	}
+}
+
+// envInt читает целое число из переменной окружения или возвращает def
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// newStylizer запускает n долгоживущих воркеров стилизации, чтобы модель не загружалась на каждое изображение.
+// При STYLE_WORKER=off или ошибке запуска каждое изображение стилизуется отдельным процессом.
+// started — сколько стилизаций можно выполнять одновременно: меньше n, если запустились не все воркеры.
+// gpu сообщает, что воркеры работают на видеокарте.
+func newStylizer(n int) (stylizer style.Stylizer, started int, gpu bool, stop func()) {
+	fallback := style.Exec{Script: style.Script}
+	if os.Getenv("STYLE_WORKER") == "off" {
+		fmt.Println("🐍 Воркер отключён (STYLE_WORKER=off), стилизация отдельными процессами")
+		return fallback, n, false, func() {}
+	}
+	fmt.Println("⏳ Запуск воркеров стилизации:", n)
+	var workers []*style.Worker
+	var stylizers []style.Stylizer
+	for i := 0; i < n; i++ {
+		worker := style.NewWorker(style.Script)
+		if err := worker.Start(); err != nil {
+			log.Println("⚠️ Не удалось запустить воркер:", err)
+			break
+		}
+		fmt.Println("🐍 Воркер стилизации запущен на", worker.Device())
+		workers = append(workers, worker)
+		stylizers = append(stylizers, worker)
+	}
+	if len(workers) == 0 {
+		log.Println("⚠️ Воркеры не запущены, стилизация отдельными процессами")
+		return fallback, n, false, func() {}
+	}
+	if len(workers) < n {
+		log.Printf("⚠️ Запущено воркеров %d из %d, одновременно выполняется не больше %d заданий\n", len(workers), n, len(workers))
+	}
+	gpu = workers[0].Device() == "cuda"
+	return style.NewPool(stylizers...), len(workers), gpu, func() {
+		for _, worker := range workers {
+			if err := worker.Close(); err != nil {
+				log.Println("⚠️ Ошибка остановки воркера:", err)
+			}
+		}
+	}
+}
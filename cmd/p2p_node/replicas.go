@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/tile"
+	"coursework_mimapr/internal/verify"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+)
+
+// replicasDir — рабочая папка копий задания внутри папки результатов, удаляется после сверки
+const replicasDir = ".replicas"
+
+// verification — выполнение каждого задания несколькими процессорами со сверкой результатов
+type verification struct {
+	Replicas   int     // сколько процессоров выполняют задание; 1 — без сверки
+	Similarity float64 // минимальное сходство (0..1), при котором результаты считаются совпавшими
+}
+
+// enabled сообщает, что задания выполняются с проверкой
+func (v verification) enabled() bool {
+	return v.Replicas > 1
+}
+
+// quorum — сколько совпавших результатов нужно, чтобы принять задание
+func (v verification) quorum() int {
+	return v.Replicas/2 + 1
+}
+
+// startJob запускает задание job: напрямую или, если включена проверка, копиями на разных процессорах
+func startJob(ctx context.Context, h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, job p2p.Job, req p2p.ImageRequest, v verification, policy retryPolicy, sendSlots chan struct{}) {
+	if v.enabled() {
+		go runReplicated(ctx, h, servers, disc, jobs, job, req, v, policy, sendSlots)
+		return
+	}
+	go runJob(ctx, h, servers, disc, jobs, req, policy, sendSlots)
+}
+
+// runReplicated отправляет v.Replicas копий задания job разным процессорам (сервер не назначает
+// копии одной группы одному процессору), сравнивает полученные результаты и принимает результат
+// большинства. Процессорам совпавших копий инициатор подтверждает оплату, несовпавшие копии
+// оспариваются — сервер возвращает их резерв и снижает репутацию процессора. Если совпавших
+// результатов меньше кворума, задание считается неудачным, а резерв всех копий возвращается.
+func runReplicated(ctx context.Context, h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, job p2p.Job, req p2p.ImageRequest, v verification, policy retryPolicy, sendSlots chan struct{}) {
+	dir := filepath.Join(job.OutputDir, replicasDir, job.ID)
+	defer os.RemoveAll(dir)
+	jobs.InFlight(job.ID)
+
+	ids := make([]string, v.Replicas)
+	for k := range ids {
+		replica := jobs.AddPart(job.ID, job.InputPath, job.StylePath, filepath.Join(dir, strconv.Itoa(k)))
+		ids[k] = replica.ID
+		r := req
+		r.JobID = replica.ID
+		r.Group = job.ID
+		go runJob(ctx, h, servers, disc, jobs, r, policy, sendSlots)
+	}
+	fmt.Printf("🔍 %s: %d копий для сверки (кворум %d)\n", job.FileName, v.Replicas, v.quorum())
+
+	replicas, err := jobs.WaitAll(ctx, ids)
+	var done []p2p.Job
+	var results []image.Image
+	for _, r := range replicas {
+		if r.State != p2p.JobDone {
+			continue
+		}
+		img, loadErr := tile.Load(r.ResultPath)
+		if loadErr != nil {
+			log.Printf("⚠️ Копия %s: %v\n", r.ID, loadErr)
+			settleReplica(h, servers, r, p2p.StatusFailed, loadErr.Error())
+			continue
+		}
+		done = append(done, r)
+		results = append(results, img)
+	}
+	if err != nil {
+		// Общий срок истёк, задание завершит awaitResults; резерв полученных копий возвращаем
+		for _, r := range done {
+			settleReplica(h, servers, r, p2p.StatusFailed, "сверка не состоялась: "+err.Error())
+		}
+		return
+	}
+	if len(done) < v.quorum() {
+		for _, r := range done {
+			settleReplica(h, servers, r, p2p.StatusFailed, "недостаточно копий для сверки")
+		}
+		jobs.Fail(job.ID, fmt.Sprintf("получено %d из %d копий, нужно %d", len(done), v.Replicas, v.quorum()))
+		return
+	}
+
+	winner, agree := verify.Majority(results, v.Similarity)
+	if len(agree) < v.quorum() {
+		for _, r := range done {
+			settleReplica(h, servers, r, p2p.StatusFailed, "результаты копий не совпали")
+		}
+		jobs.Fail(job.ID, fmt.Sprintf("совпали %d из %d копий, нужно %d", len(agree), len(done), v.quorum()))
+		return
+	}
+	agreed := make(map[int]bool, len(agree))
+	for _, i := range agree {
+		agreed[i] = true
+	}
+	// Сначала подтверждаем оплату совпавших копий: сервер принимает спор о копии,
+	// только если оплата большинства копий группы уже подтверждена
+	for i, r := range done {
+		if agreed[i] {
+			settleReplica(h, servers, r, p2p.StatusDone, "")
+		}
+	}
+	for i, r := range done {
+		if agreed[i] {
+			continue
+		}
+		similarity := verify.Similarity(results[winner], results[i])
+		fmt.Printf("⚠️ Результат %s от %s не совпал с большинством (сходство %.2f)\n", r.ID, r.Peer, similarity)
+		settleReplica(h, servers, r, p2p.StatusDisputed, fmt.Sprintf("сходство с большинством %.2f", similarity))
+	}
+
+	if err := os.MkdirAll(job.OutputDir, 0755); err != nil {
+		jobs.Fail(job.ID, err.Error())
+		return
+	}
+	resultPath := filepath.Join(job.OutputDir, job.FileName)
+	if err := os.Rename(done[winner].ResultPath, resultPath); err != nil {
+		jobs.Fail(job.ID, fmt.Sprintf("сохранение результата: %v", err))
+		return
+	}
+	if err := p2p.CombineProvenance(resultPath, []string{done[winner].ResultPath}); err != nil {
+		log.Printf("⚠️ Подпись результата %s не сохранена: %v\n", job.FileName, err)
+	}
+	jobs.Done(job.ID, resultPath, done[winner].Sizes)
+	fmt.Printf("🔍 %s: совпали %d из %d копий, принят результат %s\n", job.FileName, len(agree), len(done), done[winner].Peer)
+}
+
+// settleReplica сообщает серверу, зарезервировавшему токены копии r, итог сверки:
+// оплатить, вернуть резерв или оспорить результат
+func settleReplica(h host.Host, servers *p2p.Servers, r p2p.Job, status, reason string) {
+	if r.Server == "" {
+		return // копия назначена через DHT, токены не резервировались
+	}
+	err := p2p.ReportJob(h, servers, r.Server, r.ID, status, reason)
+	switch {
+	case errors.Is(err, p2p.ErrReportPending):
+		log.Printf("⏳ Итог сверки копии %s будет отправлен, когда сервер %s вернётся\n", r.ID, r.Server)
+	case err != nil:
+		log.Printf("⚠️ Не удалось сообщить итог сверки копии %s: %v\n", r.ID, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	p2p "coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/style"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// report — машиночитаемый итог запуска инициатора
+type report struct {
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Total    int         `json:"total"`
+	Done     int         `json:"done"`
+	Failed   int         `json:"failed"`
+	Jobs     []jobReport `json:"jobs"`
+}
+
+type jobReport struct {
+	ID         string        `json:"id"`
+	File       string        `json:"file"`
+	Input      string        `json:"input"`
+	Style      string        `json:"style"`
+	State      string        `json:"state"`
+	Peer       string        `json:"peer,omitempty"`
+	Attempts   int           `json:"attempts"`
+	Result     string        `json:"result,omitempty"`
+	Sizes      *style.Result `json:"sizes,omitempty"` // рабочий и итоговый размеры от процессора
+	Error      string        `json:"error,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+}
+
+// Фрагменты больших изображений в отчёт не попадают, их попытки суммируются в задании изображения.
+func newReport(started time.Time, jobs []p2p.Job) report {
+	r := report{Started: started, Finished: time.Now()}
+	// Попытки фрагментов и копий засчитываются исходному заданию
+	parents := make(map[string]string)
+	for _, job := range jobs {
+		parents[job.ID] = job.Parent
+	}
+	partAttempts := make(map[string]int)
+	for _, job := range jobs {
+		if job.Parent == "" {
+			continue
+		}
+		root := job.Parent
+		for parents[root] != "" {
+			root = parents[root]
+		}
+		partAttempts[root] += job.Attempts
+	}
+	for _, job := range jobs {
+		if job.Parent != "" {
+			continue
+		}
+		r.Total++
+		var sizes *style.Result
+		if job.Sizes != (style.Result{}) {
+			sizes = &job.Sizes
+		}
+		switch job.State {
+		case p2p.JobDone:
+			r.Done++
+		case p2p.JobFailed:
+			r.Failed++
+		}
+		r.Jobs = append(r.Jobs, jobReport{
+			ID:         job.ID,
+			File:       job.FileName,
+			Input:      job.InputPath,
+			Style:      job.StylePath,
+			State:      string(job.State),
+			Peer:       job.Peer.String(),
+			Attempts:   job.Attempts + partAttempts[job.ID],
+			Result:     job.ResultPath,
+			Sizes:      sizes,
+			Error:      job.Error,
+			DurationMs: job.Updated.Sub(job.Created).Milliseconds(),
+		})
+	}
+	return r
+}
+
+// printSummary выводит таблицу заданий по файлам
+func printSummary(w io.Writer, r report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ФАЙЛ\tСТИЛЬ\tСОСТОЯНИЕ\tПОПЫТОК\tВРЕМЯ\tРЕЗУЛЬТАТ")
+	for _, job := range r.Jobs {
+		outcome := job.Result
+		if job.State != string(p2p.JobDone) {
+			outcome = job.Error
+		}
+		duration := (time.Duration(job.DurationMs) * time.Millisecond).Round(100 * time.Millisecond)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", job.File, filepath.Base(job.Style), job.State, job.Attempts, duration, outcome)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Всего %d, выполнено %d, с ошибкой %d, за %s\n",
+		r.Total, r.Done, r.Failed, r.Finished.Sub(r.Started).Round(time.Second))
+}
+
+// writeReport сохраняет отчёт в JSON
+func writeReport(path string, r report) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// maxRetryDelay ограничивает паузу между попытками
+const maxRetryDelay = 2 * time.Minute
+
+// maxParallelSends — сколько изображений инициатор передаёт одновременно
+const maxParallelSends = 4
+
+// retryPolicy — сколько раз и с какими паузами повторять задание
+type retryPolicy struct {
+	MaxAttempts int           // попыток на задание, включая первую
+	BaseDelay   time.Duration // пауза после первой неудачи, дальше удваивается
+	JobTimeout  time.Duration // сколько ждать результат одной попытки
+}
+
+// backoff возвращает паузу перед попыткой attempt+1: экспоненциальный рост
+// с ограничением maxRetryDelay и случайной добавкой до 20%, чтобы задания не повторялись разом
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	if delay > 0 {
+		delay += rand.N(delay/5 + 1)
+	}
+	return delay
+}
+
+// runJob доводит задание до результата: отправляет изображение, ждёт ответ не дольше
+// JobTimeout и при ошибке повторяет отправку на другом процессоре. Процессоры, на которых
+// задание не удалось, исключаются для него при следующих запросах к серверу.
+// sendSlots ограничивает число одновременных передач.
+func runJob(ctx context.Context, h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, req p2p.ImageRequest, policy retryPolicy, sendSlots chan struct{}) {
+	var exclude []peerstore.ID
+	for attempt := 1; ; attempt++ {
+		sendSlots <- struct{}{}
+		err := dispatch(h, servers, disc, jobs, req, exclude)
+		<-sendSlots
+
+		if job, _ := jobs.Get(req.JobID); job.State.Terminal() {
+			return // общий срок истёк во время отправки
+		}
+		if err == nil {
+			jobs.InFlight(req.JobID)
+			err = awaitAttempt(ctx, h, servers, jobs, req.JobID, policy.JobTimeout)
+			if err == nil {
+				return
+			}
+		} else {
+			log.Printf("❌ Ошибка отправки %s: %v\n", req.ImagePath, err)
+			if errors.Is(err, p2p.ErrInsufficientTokens) {
+				// Повтор не поможет, пока баланс не пополнен
+				jobs.Fail(req.JobID, err.Error())
+				return
+			}
+			jobs.AttemptFailed(req.JobID, "", err.Error())
+		}
+
+		job, _ := jobs.Get(req.JobID)
+		if job.Peer != "" && !errors.Is(err, errAllBusy) && !slices.Contains(exclude, job.Peer) {
+			exclude = append(exclude, job.Peer)
+		}
+		if attempt >= policy.MaxAttempts {
+			jobs.Fail(req.JobID, fmt.Sprintf("не выполнено за %d попыток: %v", attempt, err))
+			return
+		}
+		delay := policy.backoff(attempt)
+		fmt.Printf("🔁 Задание %s (%s): попытка %d/%d не удалась, повтор через %s\n",
+			req.JobID, job.FileName, attempt, policy.MaxAttempts, delay.Round(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// awaitAttempt ждёт итог текущей попытки. nil — задание завершено (или истёк общий срок
+// ctx, тогда его завершит awaitResults), ошибка — попытку нужно повторить.
+func awaitAttempt(ctx context.Context, h host.Host, servers *p2p.Servers, jobs *p2p.JobTable, jobID string, timeout time.Duration) error {
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	job, err := jobs.WaitJob(attemptCtx, jobID, p2p.JobInFlight)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		reason := fmt.Sprintf("процессор %s не прислал результат за %s", job.Peer, timeout)
+		if !jobs.AttemptFailed(jobID, job.Peer, reason) {
+			return nil // результат пришёл в последний момент
+		}
+		// Процессор сам не сообщит об ошибке, возвращаем резерв до повторной отправки.
+		// Без сервера (процессор найден в DHT) резерва нет; недоступному серверу итог
+		// повторяется в фоне, см. p2p.ErrReportPending.
+		if job.Server != "" {
+			err := p2p.ReportTimeout(h, servers, job.Server, jobID, reason)
+			if err != nil && !errors.Is(err, p2p.ErrReportPending) {
+				log.Printf("⚠️ Не удалось вернуть токены за задание %s: %v\n", jobID, err)
+			}
+		}
+		return errors.New(reason)
+	}
+	if job.State == p2p.JobRetrying {
+		return errors.New(job.Error)
+	}
+	return nil
+}
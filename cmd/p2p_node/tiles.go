@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	p2p "coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/tile"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+)
+
+// tilesDir — рабочая папка фрагментов внутри папки результатов, удаляется после склейки
+const tilesDir = ".tiles"
+
+// tiling — деление больших изображений на фрагменты
+type tiling struct {
+	Size    int // сторона фрагмента в пикселях; 0 — не делить
+	Overlap int // перекрытие соседних фрагментов в пикселях
+}
+
+// needed сообщает, что изображение path нужно делить на фрагменты
+func (t tiling) needed(path string) bool {
+	if t.Size <= 0 {
+		return false
+	}
+	w, h, err := tile.Size(path)
+	if err != nil {
+		log.Printf("⚠️ Не удалось прочитать размер %s, отправляем целиком: %v\n", path, err)
+		return false
+	}
+	return tile.Needed(w, h, t.Size)
+}
+
+// runTiled делит изображение задания parent на перекрывающиеся фрагменты, отправляет каждый
+// фрагмент отдельным заданием (сервер распределяет их по процессорам с учётом загрузки),
+// а после получения всех фрагментов склеивает их в результат parent. Если какой-то фрагмент
+// не выполнен, задание целиком считается неудачным. Задания фрагментов строятся по req задания parent.
+func runTiled(ctx context.Context, h host.Host, servers *p2p.Servers, disc *p2p.Discovery, jobs *p2p.JobTable, parent p2p.Job, req p2p.ImageRequest, cfg tiling, v verification, policy retryPolicy, sendSlots chan struct{}) {
+	img, err := tile.Load(parent.InputPath)
+	if err != nil {
+		jobs.Fail(parent.ID, err.Error())
+		return
+	}
+	tiles := tile.Grid(img.Bounds(), cfg.Size, cfg.Overlap)
+	dir := filepath.Join(parent.OutputDir, tilesDir, parent.ID)
+	defer os.RemoveAll(dir)
+	paths, err := tile.Split(img, tiles, filepath.Join(dir, "in"))
+	if err != nil {
+		jobs.Fail(parent.ID, fmt.Sprintf("деление на фрагменты: %v", err))
+		return
+	}
+	jobs.InFlight(parent.ID)
+
+	// Фрагмент стилизуется в своём разрешении и возвращается того же размера
+	req.Params.KeepAspect = true
+	if req.Params.Size == 0 {
+		req.Params.Size = cfg.Size
+	}
+	ids := make([]string, len(paths))
+	for i, path := range paths {
+		part := jobs.AddPart(parent.ID, path, parent.StylePath, filepath.Join(dir, "out"))
+		ids[i] = part.ID
+		partReq := req
+		partReq.JobID, partReq.ImagePath = part.ID, path
+		startJob(ctx, h, servers, disc, jobs, *part, partReq, v, policy, sendSlots)
+	}
+	fmt.Printf("🧩 %s: %d фрагментов %dx%d с перекрытием %d\n", parent.FileName, len(tiles), cfg.Size, cfg.Size, cfg.Overlap)
+
+	parts, err := jobs.WaitAll(ctx, ids)
+	if err != nil {
+		return // общий срок истёк, задание завершит awaitResults
+	}
+	results := make([]image.Image, len(parts))
+	for i, part := range parts {
+		if part.State != p2p.JobDone {
+			jobs.Fail(parent.ID, fmt.Sprintf("фрагмент %d не выполнен: %s", i, part.Error))
+			return
+		}
+		if results[i], err = tile.Load(part.ResultPath); err != nil {
+			jobs.Fail(parent.ID, fmt.Sprintf("фрагмент %d: %v", i, err))
+			return
+		}
+	}
+
+	out := tile.Blend(img.Bounds(), tiles, results, cfg.Overlap)
+	resultPath := filepath.Join(parent.OutputDir, parent.FileName)
+	if err := os.MkdirAll(parent.OutputDir, 0755); err != nil {
+		jobs.Fail(parent.ID, err.Error())
+		return
+	}
+	if err := tile.Save(resultPath, out); err != nil {
+		jobs.Fail(parent.ID, fmt.Sprintf("сохранение результата: %v", err))
+		return
+	}
+	partPaths := make([]string, len(parts))
+	for i, part := range parts {
+		partPaths[i] = part.ResultPath
+	}
+	if err := p2p.CombineProvenance(resultPath, partPaths); err != nil {
+		log.Printf("⚠️ Подписи фрагментов %s не сохранены: %v\n", parent.FileName, err)
+	}
+	sizes := parts[0].Sizes
+	sizes.Width, sizes.Height = out.Bounds().Dx(), out.Bounds().Dy()
+	jobs.Done(parent.ID, resultPath, sizes)
+	fmt.Printf("🧩 Фрагменты %s склеены: %s\n", parent.FileName, resultPath)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Использование административных команд сервера
+const adminUsage = "использование: server enable|disable <peer-id> | server token [-ttl 24h] [-peer <peer-id>]"
+
+// runAdmin выполняет административную команду и завершается:
+//
+//	server enable <peer-id>  — включить узел и обнулить его репутацию
+//	server disable <peer-id> — отключить узел вручную
+//	server token             — выпустить одноразовый токен допуска к серверу в закрытом режиме
+//
+// Команды работают с той же БД и тем же ключом, что и запущенный сервер.
+func runAdmin(args []string) error {
+	switch args[0] {
+	case "enable", "disable":
+		return setEnabled(args)
+	case "token":
+		return printToken(args[1:])
+	}
+	return errors.New(adminUsage)
+}
+
+// setEnabled включает или отключает узел; действует на следующее назначение
+func setEnabled(args []string) error {
+	if len(args) != 2 {
+		return errors.New(adminUsage)
+	}
+	id, err := peer.Decode(args[1])
+	if err != nil {
+		return fmt.Errorf("неверный ID пира %q: %w", args[1], err)
+	}
+	peerID := id.String()
+	if err := db.Init("tokens.db"); err != nil {
+		return fmt.Errorf("не удалось инициализировать БД: %w", err)
+	}
+
+	if args[0] == "enable" {
+		err = db.Reinstate(peerID)
+	} else {
+		err = db.SetEnabled(peerID, false)
+	}
+	if err != nil {
+		return err
+	}
+	u, err := db.GetUser(peerID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s: включён %t, репутация %d (выполнено %d, ошибок %d, таймаутов %d, оспорено %d)\n",
+		peerID, u.Enabled, u.Reputation, u.JobsDone, u.JobsFailed, u.JobsTimedOut, u.JobsDisputed)
+	return nil
+}
+
+// printToken выпускает токен допуска, подписанный ключом сервера, и печатает его.
+// Узел передаёт токен флагом --token или переменной ENROLL_TOKEN.
+func printToken(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 24*time.Hour, "срок действия токена")
+	peerFlag := fs.String("peer", "", "выпустить токен только для узла с этим ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || *ttl <= 0 {
+		return errors.New(adminUsage)
+	}
+	var peerID string
+	if *peerFlag != "" {
+		id, err := peer.Decode(*peerFlag)
+		if err != nil {
+			return fmt.Errorf("неверный ID пира %q: %w", *peerFlag, err)
+		}
+		peerID = id.String()
+	}
+	key, err := p2p.LoadOrCreateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	token, err := issueToken(key, peerID, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p/core/crypto"
+	network "github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// tokenDomain отделяет подпись токена допуска от других подписей ключом сервера
+const tokenDomain = "coursework-enroll:"
+
+// Закрытый режим сервера: узел попадает в peers, только если его ID есть в списке
+// разрешённых или он предъявил при регистрации токен допуска, подписанный ключом сервера.
+// Каждый токен допускает один узел: использованные токены и допущенные по ним узлы
+// хранятся в БД (db.UseEnrollment) и переживают перезапуск сервера.
+// Закрытый режим ограничивает только протоколы сервера, а не DHT (флаг -dht).
+var (
+	closed        bool                     // флаг -closed
+	enrollTimeout = 15 * time.Second       // сколько ждать регистрации с токеном (флаг -enroll-timeout)
+	allowlist     = make(map[peer.ID]bool) // ID из файла -allowlist
+	admitted      = make(map[peer.ID]bool) // узлы, допущенные по токену (защищено lock)
+	serverKey     crypto.PrivKey           // ключ сервера, которым подписаны токены
+)
+
+// enrollment — содержимое токена допуска
+type enrollment struct {
+	ID      string `json:"id"`             // идентификатор токена, по нему токен используется один раз
+	Peer    string `json:"peer,omitempty"` // ID узла, для которого выпущен токен; пусто — первый предъявивший
+	Expires int64  `json:"exp"`            // срок действия, Unix-время
+}
+
+// loadAllowlist читает ID разрешённых узлов: по одному в строке, # — комментарий
+func loadAllowlist(path string) (map[peer.ID]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ids := make(map[peer.ID]bool)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		id, err := peer.Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: неверный ID пира %q: %w", path, n, line, err)
+		}
+		ids[id] = true
+	}
+	return ids, scanner.Err()
+}
+
+// loadAdmitted восстанавливает из БД узлы, допущенные по токенам до перезапуска
+func loadAdmitted() error {
+	ids, err := db.EnrolledPeers()
+	if err != nil {
+		return err
+	}
+	for _, raw := range ids {
+		if id, err := peer.Decode(raw); err == nil {
+			admitted[id] = true
+		}
+	}
+	return nil
+}
+
+// issueToken выпускает одноразовый токен допуска со сроком ttl. Если указан peerID, токен
+// действует только для этого узла, иначе — для первого узла, который его предъявит.
+func issueToken(key crypto.PrivKey, peerID string, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(enrollment{ID: p2p.NewJobID(), Peer: peerID, Expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	sig, err := key.Sign(append([]byte(tokenDomain), payload...))
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(sig), nil
+}
+
+// verifyToken проверяет подпись, срок действия и получателя токена и возвращает его идентификатор.
+// Использован ли токен, проверяет db.UseEnrollment.
+func verifyToken(key crypto.PubKey, token string, peerID peer.ID) (string, error) {
+	if token == "" {
+		return "", errors.New("токен не предъявлен")
+	}
+	rawPayload, rawSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errors.New("неверный формат токена")
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(rawPayload)
+	if err != nil {
+		return "", fmt.Errorf("неверный формат токена: %w", err)
+	}
+	sig, err := enc.DecodeString(rawSig)
+	if err != nil {
+		return "", fmt.Errorf("неверный формат токена: %w", err)
+	}
+	valid, err := key.Verify(append([]byte(tokenDomain), payload...), sig)
+	if err != nil || !valid {
+		return "", errors.New("подпись токена недействительна")
+	}
+	var e enrollment
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return "", fmt.Errorf("неверное содержимое токена: %w", err)
+	}
+	if e.ID == "" {
+		return "", errors.New("токен без идентификатора выпущен старой версией сервера, выпустите новый")
+	}
+	if time.Now().Unix() > e.Expires {
+		return "", fmt.Errorf("срок токена истёк %s", time.Unix(e.Expires, 0).Format(time.RFC3339))
+	}
+	if e.Peer != "" && e.Peer != peerID.String() {
+		return "", fmt.Errorf("токен выпущен для другого узла %s", e.Peer)
+	}
+	return e.ID, nil
+}
+
+// isAdmitted сообщает, допущен ли узел к работе с сервером. Вызывается под lock.
+func isAdmitted(id peer.ID) bool {
+	return !closed || allowlist[id] || admitted[id]
+}
+
+// awaitEnrollment отключает узел, если он не зарегистрировался с токеном за enrollTimeout
+func awaitEnrollment(net network.Network, id peer.ID) {
+	time.Sleep(enrollTimeout)
+	lock.Lock()
+	ok := isAdmitted(id)
+	lock.Unlock()
+	if ok || net.Connectedness(id) != network.Connected {
+		return
+	}
+	fmt.Printf("⛔ %s не допущен за %s (нет действительного токена), отключаем\n", id, enrollTimeout)
+	net.ClosePeer(id)
+}
+
+// guard пропускает к handler только потоки допущенных узлов; в открытом режиме — все потоки
+func guard(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		id := s.Conn().RemotePeer()
+		lock.Lock()
+		ok := isAdmitted(id)
+		lock.Unlock()
+		if !ok {
+			fmt.Printf("⛔ Поток %s от недопущенного узла %s отклонён\n", s.Protocol(), id)
+			s.Reset()
+			return
+		}
+		handler(s)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/p2p/frame"
+	"fmt"
+	"log"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	network "github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Обработчик регистрации роли узла по протоколу "/register/1.0.0".
+// Роль сохраняется в БД через db.SetMode, при первой регистрации начисляются стартовые токены.
+// В закрытом режиме узел вне списка допущенных должен предъявить токен допуска (MetaToken),
+// иначе регистрация отклоняется, а по истечении enrollTimeout узел отключается.
+func handleRegister(s network.Stream, h host.Host) {
+	defer s.Close()
+	peerID := s.Conn().RemotePeer()
+
+	f, err := frame.Expect(s, frame.TypeRegister)
+	if err != nil {
+		log.Println("⚠️ Ошибка чтения регистрации:", err)
+		return
+	}
+	if !admit(s, f, h) {
+		return // узел отключит awaitEnrollment, когда он прочитает ошибку
+	}
+	mode := f.Get(p2p.MetaMode)
+	if err := db.SetMode(peerID.String(), mode); err != nil {
+		log.Printf("❌ Не удалось зарегистрировать %s с ролью %q: %v\n", peerID, mode, err)
+		frame.Write(s, frame.New(frame.TypeError, nil, []byte("регистрация отклонена: "+err.Error())))
+		return
+	}
+	// Новый узел получает стартовый баланс, чтобы мог отправлять задания
+	granted, err := db.GrantOnce(peerID.String(), initialTokens)
+	if err != nil {
+		log.Printf("⚠️ Не удалось начислить стартовые токены %s: %v\n", peerID, err)
+	} else if granted {
+		fmt.Printf("🪙 Пиру %s начислено %d токенов\n", peerID, initialTokens)
+	}
+	frame.Write(s, frame.New(frame.TypeAck, map[string]string{p2p.MetaMode: mode}, nil))
+	fmt.Printf("📝 Пир %s зарегистрирован с ролью %s\n", peerID, mode)
+}
+
+// admit проверяет допуск узла, зарегистрировавшегося потоком s. Узел, предъявивший
+// действительный и ещё не использованный другим узлом токен, запоминается (в БД) и
+// добавляется в peers; недопущенный получает ошибку.
+func admit(s network.Stream, f *frame.Frame, h host.Host) bool {
+	peerID := s.Conn().RemotePeer()
+	lock.Lock()
+	if isAdmitted(peerID) {
+		lock.Unlock()
+		return true
+	}
+	tokenID, err := verifyToken(serverKey.GetPublic(), f.Get(p2p.MetaToken), peerID)
+	if err == nil {
+		err = db.UseEnrollment(tokenID, peerID.String())
+	}
+	if err != nil {
+		lock.Unlock()
+		fmt.Printf("⛔ Пир %s не допущен: %v\n", peerID, err)
+		frame.Write(s, frame.New(frame.TypeError, nil, []byte("узел не допущен: "+err.Error())))
+		return false
+	}
+	admitted[peerID] = true
+	_, known := peers[peerID]
+	lock.Unlock()
+	fmt.Println("✅ Пир допущен по токену:", peerID)
+	if !known {
+		addPeer(s.Conn(), h)
+	}
+	return true
+}
+
+// eligibleProcessors возвращает пиров, которым можно назначать задания:
+// они должны быть включены и иметь роль "processor" или "all"
+func eligibleProcessors() map[peer.ID]bool {
+	ids, err := db.Processors()
+	if err != nil {
+		log.Println("⚠️ Не удалось прочитать процессоры из БД:", err)
+		return nil
+	}
+	eligible := make(map[peer.ID]bool, len(ids))
+	for id := range ids {
+		if peerID, err := peer.Decode(id); err == nil {
+			eligible[peerID] = true
+		}
+	}
+	return eligible
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"coursework_mimapr/internal/p2p"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// groupTTL — сколько сервер помнит процессоры копий одного задания
+const groupTTL = 24 * time.Hour
+
+// replicaGroup — процессоры, которым назначены копии одного задания, и итоги сверки копий
+type replicaGroup struct {
+	peers    map[peer.ID]bool
+	jobs     map[string]bool // идентификаторы копий
+	paid     int             // копий, оплату которых подтвердил инициатор
+	disputed int             // копий, оспоренных инициатором
+	updated  time.Time
+}
+
+// groups — копии заданий по идентификатору исходного задания (защищено lock)
+var groups = make(map[string]*replicaGroup)
+
+// groupPeers возвращает процессоры, уже получившие копии задания group,
+// и забывает группы, которые давно не менялись. Вызывается под lock.
+func groupPeers(group string) map[peer.ID]bool {
+	for id, g := range groups {
+		if time.Since(g.updated) > groupTTL {
+			delete(groups, id)
+		}
+	}
+	if g, ok := groups[group]; ok {
+		return g.peers
+	}
+	return nil
+}
+
+// joinGroup запоминает, что копия jobID задания group назначена processor. Вызывается под lock.
+func joinGroup(group, jobID string, processor peer.ID) {
+	g, ok := groups[group]
+	if !ok {
+		g = &replicaGroup{peers: make(map[peer.ID]bool), jobs: make(map[string]bool)}
+		groups[group] = g
+	}
+	g.peers[processor] = true
+	g.jobs[jobID] = true
+	g.updated = time.Now()
+}
+
+// groupOf возвращает группу, в которую входит копия jobID, или nil. Вызывается под lock.
+func groupOf(jobID string) *replicaGroup {
+	for _, g := range groups {
+		if g.jobs[jobID] {
+			return g
+		}
+	}
+	return nil
+}
+
+// canDispute сообщает, может ли инициатор оспорить копию jobID: оспоренная копия
+// возвращает ему резерв, поэтому оспорить можно только меньшинство копий группы,
+// оплата большинства которых уже подтверждена. Иначе инициатор получал бы результат
+// бесплатно и снижал репутацию процессоров по своему усмотрению. Вызывается под lock.
+func canDispute(jobID string) bool {
+	g := groupOf(jobID)
+	return g != nil && g.paid > g.disputed+1
+}
+
+// recordSettlement учитывает в группе итог сверки копии jobID. Вызывается под lock.
+func recordSettlement(jobID, status string) {
+	g := groupOf(jobID)
+	if g == nil {
+		return
+	}
+	switch status {
+	case p2p.StatusDone:
+		g.paid++
+	case p2p.StatusDisputed:
+		g.disputed++
+	}
+	g.updated = time.Now()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/sched"
+	"fmt"
+	"log"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// outcomeWeights — изменение репутации процессора за каждый итог задания
+var outcomeWeights = map[string]int{
+	db.OutcomeDone:     1,
+	db.OutcomeFailed:   -2,
+	db.OutcomeTimeout:  -3,
+	db.OutcomeDisputed: -5,
+}
+
+// minReputation — процессор с репутацией ниже порога отключается (флаг -min-reputation)
+var minReputation = -20
+
+// Таймаут сообщает только инициатор, поэтому он засчитывается процессору, лишь если
+// это подтверждается: процессор перестал присылать heartbeat или о таймаутах за
+// timeoutWindow сообщили не меньше timeoutWitnesses разных инициаторов. Каждый инициатор
+// за timeoutWindow снижает репутацию процессора за таймауты не больше одного раза.
+const (
+	timeoutWitnesses = 3
+	timeoutWindow    = time.Hour
+)
+
+// timeoutReport — таймауты процессора по отчётам одного инициатора
+type timeoutReport struct {
+	last    time.Time // последний отчёт о таймауте
+	counted time.Time // когда таймаут по его отчёту последний раз засчитан
+}
+
+// timeoutReports — отчёты о таймаутах процессоров по инициаторам (защищено lock)
+var timeoutReports = make(map[string]map[string]*timeoutReport)
+
+// outcome определяет, какой итог засчитать процессору задания по отчёту reporter.
+// Процессору засчитываются оплата, собственные ошибки, таймауты по отчёту инициатора
+// (если их подтверждает confirmTimeout) и оспоренные инициатором копии; прочие неудачи
+// (например, процессор недоступен или занят) не по его вине. Пустая строка — итог не учитывается.
+func outcome(r *db.Reservation, reporter, status, cause string) string {
+	switch {
+	case status == p2p.StatusDone:
+		return db.OutcomeDone
+	case status == p2p.StatusDisputed && r.Verify && reporter == r.Initiator:
+		return db.OutcomeDisputed
+	case status == p2p.StatusFailed && reporter == r.Processor:
+		return db.OutcomeFailed
+	case status == p2p.StatusFailed && reporter == r.Initiator && cause == p2p.CauseTimeout:
+		return db.OutcomeTimeout
+	}
+	return ""
+}
+
+// confirmTimeout запоминает таймаут процессора по отчёту initiator и сообщает, засчитывать ли его:
+// процессор молчит (нет свежего heartbeat) или о таймаутах за timeoutWindow сообщили
+// timeoutWitnesses разных инициаторов, и таймаут от initiator за окно ещё не засчитывался.
+// Иначе любой инициатор мог бы отключить процессор, сообщая о таймаутах, которых не было.
+// Вызывается под lock.
+func confirmTimeout(processor, initiator string) bool {
+	reports, ok := timeoutReports[processor]
+	if !ok {
+		reports = make(map[string]*timeoutReport)
+		timeoutReports[processor] = reports
+	}
+	for id, r := range reports {
+		if time.Since(r.last) > timeoutWindow {
+			delete(reports, id)
+		}
+	}
+	r, ok := reports[initiator]
+	if !ok {
+		r = &timeoutReport{}
+		reports[initiator] = r
+	}
+	r.last = time.Now()
+	if time.Since(r.counted) < timeoutWindow {
+		fmt.Printf("⏳ Таймаут %s по отчёту %s не засчитан: от этого инициатора уже засчитан за %s\n", processor, initiator, timeoutWindow)
+		return false
+	}
+
+	silent := true
+	if id, err := peer.Decode(processor); err == nil {
+		if load, ok := loads[id]; ok && time.Since(load.Updated) <= sched.StaleAfter {
+			silent = false
+		}
+	}
+	if !silent && len(reports) < timeoutWitnesses {
+		fmt.Printf("⏳ Таймаут %s по отчёту %s не засчитан: процессор присылает heartbeat, инициаторов с таймаутами %d из %d\n",
+			processor, initiator, len(reports), timeoutWitnesses)
+		return false
+	}
+	r.counted = r.last
+	return true
+}
+
+// recordOutcome учитывает итог задания в репутации процессора и отключает его,
+// если репутация опустилась ниже minReputation. Включить узел обратно можно
+// командой "server enable <peer-id>".
+func recordOutcome(processor, outcome string) {
+	delta := outcomeWeights[outcome]
+	u, err := db.RecordOutcome(processor, outcome, delta)
+	if err != nil {
+		log.Printf("❌ Ошибка учёта итога %s для %s: %v\n", outcome, processor, err)
+		return
+	}
+	fmt.Printf("⭐ Репутация %s: %s %+d ➜ %d\n", processor, outcome, delta, u.Reputation)
+	if !u.Enabled || u.Reputation >= minReputation {
+		return
+	}
+	if err := db.SetEnabled(processor, false); err != nil {
+		log.Printf("❌ Не удалось отключить %s: %v\n", processor, err)
+		return
+	}
+	fmt.Printf("🚫 %s отключён: репутация %d ниже %d (выполнено %d, ошибок %d, таймаутов %d, оспорено %d)\n",
+		processor, u.Reputation, minReputation, u.JobsDone, u.JobsFailed, u.JobsTimedOut, u.JobsDisputed)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/sched"
+	"fmt"
+	"log"
+
+	network "github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// loads — последняя загрузка каждого процессора из heartbeat (защищено lock)
+	loads = make(map[peer.ID]*sched.Load)
+	// strategy выбирает процессор в handlePeerRequest (флаг -scheduler)
+	strategy sched.Strategy
+)
+
+// Обработчик heartbeat процессоров по протоколу "/heartbeat/1.0.0"
+func handleHeartbeat(s network.Stream) {
+	defer s.Close()
+	f, err := frame.Expect(s, frame.TypeHeartbeat)
+	if err != nil {
+		log.Println("⚠️ Ошибка чтения heartbeat:", err)
+		return
+	}
+	load := sched.DecodeLoad(f.Meta)
+
+	lock.Lock()
+	defer lock.Unlock()
+	peerID := s.Conn().RemotePeer()
+	loads[peerID] = &load
+}
+
+// pickReceiver выбирает процессор для задания от sender среди подходящих пиров,
+// кроме исключённых инициатором для этого задания. Вызывается под lock.
+func pickReceiver(sender peer.ID, exclude map[peer.ID]bool) (peer.ID, bool) {
+	eligible := eligibleProcessors()
+	candidates := make([]sched.Candidate, 0, len(peerList))
+	for _, id := range peerList {
+		if id == sender || exclude[id] || !eligible[id] {
+			continue
+		}
+		candidates = append(candidates, sched.Candidate{ID: id, Load: loads[id]})
+	}
+	receiverID, ok := sched.Assign(strategy, candidates)
+	if !ok {
+		return "", false
+	}
+	if load, ok := loads[receiverID]; ok {
+		fmt.Printf("📈 %s: очередь %d/%d, в работе %d/%d, ожидание ~%s\n",
+			receiverID, load.Queued, load.Depth, load.Active, load.Workers, load.Wait())
+	}
+	return receiverID, true
+}
+
+// assign выбирает процессор для sender и возвращает его адреса. Вызывается под lock.
+func assign(sender peer.ID, exclude map[peer.ID]bool) (peer.AddrInfo, bool) {
+	receiverID, ok := pickReceiver(sender, exclude)
+	if !ok {
+		fmt.Println("❌ Нет подходящих процессоров для распределения.")
+		return peer.AddrInfo{}, false
+	}
+	receiverInfo, ok := peers[receiverID]
+	if !ok || len(receiverInfo.Addrs) == 0 {
+		fmt.Println("⚠️ Назначенный пир невалидный:", receiverID)
+		unassign(receiverID)
+		return peer.AddrInfo{}, false
+	}
+	return receiverInfo, true
+}
+
+// unassign отменяет учёт задания, назначенного pickReceiver, если оно не было отправлено. Вызывается под lock.
+func unassign(receiverID peer.ID) {
+	if load, ok := loads[receiverID]; ok && load.Queued > 0 {
+		load.Queued--
+	}
+}
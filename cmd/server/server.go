@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -9,9 +12,10 @@ import (
 	"time"
 
 	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/sched"
 
 	libp2p "github.com/libp2p/go-libp2p"
-	crypto "github.com/libp2p/go-libp2p/core/crypto"
 	host "github.com/libp2p/go-libp2p/core/host"
 	network "github.com/libp2p/go-libp2p/core/network"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -21,21 +25,66 @@ import (
 const keyFile = "bootstrap_key.pem"
 
 var (
-	peers     = make(map[peer.ID]peer.AddrInfo)
-	peerList  []peer.ID
-	nextIndex int
-	lock      sync.Mutex
+	peers    = make(map[peer.ID]peer.AddrInfo)
+	peerList []peer.ID
+	lock     sync.Mutex
 )
 
 func main() {
+	schedulerName := flag.String("scheduler", "least-loaded", "стратегия выбора процессора: least-loaded или round-robin")
+	flag.IntVar(&jobCost, "job-cost", jobCost, "стоимость одного задания в токенах")
+	flag.IntVar(&initialTokens, "initial-tokens", initialTokens, "токены, начисляемые узлу при первой регистрации")
+	flag.IntVar(&minReputation, "min-reputation", minReputation, "процессор с репутацией ниже порога отключается")
+	flag.BoolVar(&closed, "closed", false, "закрытый режим: допускать только узлы из -allowlist или с одноразовым токеном допуска (server token); допущенные по токену узлы хранятся в БД. Не ограничивает участие в DHT (-dht)")
+	allowlistFile := flag.String("allowlist", "", "файл с ID допущенных узлов, по одному в строке")
+	flag.DurationVar(&enrollTimeout, "enroll-timeout", enrollTimeout, "сколько ждать регистрации с токеном в закрытом режиме")
+	advertiseLAN := flag.Bool("mdns", false, "объявлять сервер в локальной сети (mDNS), чтобы узлы находили его без bootstrap.txt")
+	joinDHT := flag.Bool("dht", false, "быть узлом входа в DHT для узлов, которые ищут процессоры без сервера (p2p_node --dht)")
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		if err := runAdmin(flag.Args()); err != nil {
+			log.Fatal("❌ ", err)
+		}
+		return
+	}
+
+	var err error
+	strategy, err = sched.New(*schedulerName)
+	if err != nil {
+		log.Fatal("❌ ", err)
+	}
+	fmt.Println("🧮 Стратегия планирования:", *schedulerName)
+
 	if err := db.Init("tokens.db"); err != nil {
 		log.Fatal("❌ Не удалось инициализировать БД:", err)
 	}
-	log.Println("✅ БД подключена и таблица users готова")
-	privKey, err := loadOrCreateKey()
+	version, err := db.SchemaVersion(db.Conn)
+	if err != nil {
+		log.Fatal("❌ Не удалось прочитать версию схемы БД:", err)
+	}
+	log.Println("✅ БД подключена, версия схемы", version)
+	fmt.Printf("🪙 Стоимость задания %d, начальный баланс %d\n", jobCost, initialTokens)
+	fmt.Println("⭐ Порог отключения процессоров по репутации:", minReputation)
+	privKey, err := p2p.LoadOrCreateKey(keyFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	serverKey = privKey
+	if *allowlistFile != "" {
+		if allowlist, err = loadAllowlist(*allowlistFile); err != nil {
+			log.Fatal("❌ Ошибка чтения списка допущенных узлов:", err)
+		}
+	}
+	if closed {
+		if err := loadAdmitted(); err != nil {
+			log.Fatal("❌ Ошибка чтения допущенных по токенам узлов:", err)
+		}
+		fmt.Printf("🔒 Закрытый режим: допущенных узлов в списке %d, по токенам %d, остальным нужен токен (server token)\n", len(allowlist), len(admitted))
+		if *joinDHT {
+			fmt.Println("⚠️ Закрытый режим не ограничивает DHT: недопущенные узлы могут входить в неё через сервер")
+		}
+	}
 
 	h, err := libp2p.New(
 		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/9000"),
@@ -63,45 +112,63 @@ func main() {
 	}
 	fmt.Println("✅ Записан bootstrap multiaddr:", bootstrapLine)
 
-	h.SetStreamHandler("/request-peer/1.0.0", handlePeerRequest)
+	h.SetStreamHandler(p2p.ProtoRequestPeer, guard(handlePeerRequest))
+	h.SetStreamHandler(p2p.ProtoRequestPeerV1, guard(handlePeerRequestV1))
+	h.SetStreamHandler(p2p.ProtoReport, guard(handleReport))
+	h.SetStreamHandler(p2p.ProtoHeartbeat, guard(handleHeartbeat))
+	// Регистрация доступна всем: с ней узел предъявляет токен допуска
+	h.SetStreamHandler(p2p.ProtoRegister, func(s network.Stream) { handleRegister(s, h) })
+
+	// Сервер — постоянный узел DHT, через который в неё входят узлы с --dht;
+	// процессоры они находят в DHT сами, даже если сервер потом станет недоступен
+	if *joinDHT {
+		if _, err := p2p.StartDHT(context.Background(), h, nil); err != nil {
+			log.Fatal("❌ Ошибка запуска DHT:", err)
+		}
+	}
 
 	h.Network().Notify(&network.NotifyBundle{
 		ConnectedF:    func(n network.Network, c network.Conn) { onPeerConnected(n, c, h) },
 		DisconnectedF: onPeerDisconnected,
 	})
 
-	select {}
-}
-
-// Загружаем или создаём приватный ключ
-func loadOrCreateKey() (crypto.PrivKey, error) {
-	if _, err := os.Stat(keyFile); err == nil {
-		data, err := os.ReadFile(keyFile)
-		if err != nil {
-			return nil, err
+	// Узлы в локальной сети находят сервер без bootstrap.txt; найденные сервером узлы
+	// подключаются и учитываются так же, как подключившиеся сами
+	if *advertiseLAN {
+		if _, _, err := p2p.StartMDNS(h); err != nil {
+			log.Fatal("❌ ", err)
 		}
-		return crypto.UnmarshalPrivateKey(data)
+		fmt.Println("🏠 Сервер объявлен в локальной сети как", p2p.MDNSService)
 	}
 
-	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
-	if err != nil {
-		return nil, err
-	}
-	data, err := crypto.MarshalPrivateKey(privKey)
-	if err != nil {
-		return nil, err
-	}
-	if err := os.WriteFile(keyFile, data, 0600); err != nil {
-		return nil, err
-	}
-	return privKey, nil
+	select {}
 }
 
-// Обработчик подключения
+// Обработчик подключения. В закрытом режиме узел вне списка допущенных добавляется
+// только после регистрации с токеном, а без неё через enrollTimeout отключается.
 func onPeerConnected(net network.Network, conn network.Conn, h host.Host) {
-	lock.Lock()
-	defer lock.Unlock()
+	peerID := conn.RemotePeer()
+	if closed {
+		lock.Lock()
+		allowed, enrolled := allowlist[peerID], admitted[peerID]
+		lock.Unlock()
+		switch {
+		case allowed:
+			fmt.Println("✅ Пир допущен по списку:", peerID)
+		case enrolled:
+			fmt.Println("✅ Пир допущен по ранее предъявленному токену:", peerID)
+		default:
+			fmt.Printf("⏳ Пир %s не в списке допущенных, ждём токен %s\n", peerID, enrollTimeout)
+			go awaitEnrollment(net, peerID)
+			return
+		}
+	}
+	addPeer(conn, h)
+}
 
+// addPeer добавляет подключённый узел в peers. Адреса узла ждёт без lock, чтобы
+// назначения и итоги заданий не простаивали, пока подключается новый узел.
+func addPeer(conn network.Conn, h host.Host) {
 	peerID := conn.RemotePeer()
 
 	// Ждём до 5 секунд, пока появятся адреса
@@ -126,8 +193,16 @@ func onPeerConnected(net network.Network, conn network.Conn, h host.Host) {
 		return
 	}
 
+	lock.Lock()
+	defer lock.Unlock()
+	// Пока ждали адреса, узел мог отключиться или уже быть добавлен по другому соединению
+	if h.Network().Connectedness(peerID) != network.Connected {
+		return
+	}
+	if _, ok := peers[peerID]; !ok {
+		peerList = append(peerList, peerID)
+	}
 	peers[peerID] = peer.AddrInfo{ID: peerID, Addrs: addrs}
-	peerList = append(peerList, peerID)
 	fmt.Println("🔗 Новый пир подключен:", peerID)
 }
 
@@ -138,6 +213,7 @@ func onPeerDisconnected(net network.Network, conn network.Conn) {
 
 	peerID := conn.RemotePeer()
 	delete(peers, peerID)
+	delete(loads, peerID)
 
 	// Удаляем из списка peerList
 	for i, id := range peerList {
@@ -150,37 +226,32 @@ func onPeerDisconnected(net network.Network, conn network.Conn) {
 	fmt.Println("❌ Пир отключен:", peerID)
 }
 
-// Назначение пира по кругу
-func handlePeerRequest(s network.Stream) {
-	lock.Lock()
-	defer lock.Unlock()
-
+// Назначение пира выбранной стратегией по протоколу "/request-peer/1.0.0" для узлов старой версии.
+// Запрос не содержит jobID, а итоги таких заданий не сообщаются, поэтому резерв закрыть нечем:
+// стоимость задания сразу переводится процессору (db.ChargeLegacy) и не возвращается.
+// Инициатор с балансом меньше стоимости задания получает INSUFFICIENT_TOKENS.
+func handlePeerRequestV1(s network.Stream) {
+	defer s.Close()
 	sender := s.Conn().RemotePeer()
-	var receiverID peer.ID
 
-	if len(peerList) <= 1 {
-		fmt.Println("❌ Недостаточно пиров для распределения.")
-		s.Write([]byte("NO_PEER"))
-		s.Close()
+	lock.Lock()
+	defer lock.Unlock()
+	receiverInfo, ok := assign(sender, nil)
+	if !ok {
+		s.Write([]byte(p2p.CodeNoPeer))
 		return
 	}
-
-	// Находим следующего пира ≠ отправителю
-	tries := 0
-	for tries < len(peerList) {
-		receiverID = peerList[nextIndex%len(peerList)]
-		nextIndex++
-		if receiverID != sender {
-			break
+	jobID := "v1-" + p2p.NewJobID()
+	balance, err := db.ChargeLegacy(jobID, sender.String(), receiverInfo.ID.String(), jobCost)
+	if err != nil {
+		unassign(receiverInfo.ID)
+		if errors.Is(err, db.ErrInsufficientTokens) {
+			fmt.Printf("🪙 У %s недостаточно токенов: %d < %d\n", sender, balance, jobCost)
+			s.Write([]byte(p2p.CodeInsufficientTokens))
+			return
 		}
-		tries++
-	}
-
-	receiverInfo, ok := peers[receiverID]
-	if !ok || len(receiverInfo.Addrs) == 0 {
-		fmt.Println("⚠️ Назначенный пир невалидный:", receiverID)
-		s.Write([]byte("NO_PEER"))
-		s.Close()
+		log.Println("❌ Ошибка оплаты задания протокола 1.0.0:", err)
+		s.Write([]byte(p2p.CodeNoPeer))
 		return
 	}
 
@@ -188,10 +259,8 @@ func handlePeerRequest(s network.Stream) {
 	for _, addr := range receiverInfo.Addrs {
 		addrList = append(addrList, addr.String())
 	}
-	response := fmt.Sprintf("%s|%s", receiverID.String(), strings.Join(addrList, ","))
-
+	response := fmt.Sprintf("%s|%s", receiverInfo.ID.String(), strings.Join(addrList, ","))
 	s.Write([]byte(response))
-	s.Close()
 
-	fmt.Printf("📤 Назначен получатель для %s ➜ %s\n", sender, receiverID)
+	fmt.Printf("📤 Назначен получатель для %s ➜ %s (протокол 1.0.0, оплачено %d, баланс %d)\n", sender, receiverInfo.ID, jobCost, balance)
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"coursework_mimapr/internal/db"
+	"coursework_mimapr/internal/p2p"
+	"coursework_mimapr/internal/p2p/frame"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	network "github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// jobCost — сколько токенов резервируется с инициатора за одно задание (флаг -job-cost)
+	jobCost = 1
+	// initialTokens — сколько токенов получает узел при первой регистрации (флаг -initial-tokens)
+	initialTokens = 10
+)
+
+// Назначение процессора для задания по протоколу "/request-peer/2.0.0".
+// Стоимость задания резервируется на балансе инициатора и записывается в ledger с jobID.
+// Если баланса не хватает, инициатор получает ошибку с кодом INSUFFICIENT_TOKENS.
+// Процессоры из метаданных exclude для этого задания не выбираются.
+// Копии одного задания (метаданные group) назначаются разным процессорам
+// и оплачиваются только после сверки результатов инициатором.
+func handlePeerRequest(s network.Stream) {
+	defer s.Close()
+	sender := s.Conn().RemotePeer()
+
+	f, err := frame.Expect(s, frame.TypePeerRequest)
+	if err != nil {
+		log.Println("⚠️ Ошибка чтения запроса назначения:", err)
+		return
+	}
+	jobID := f.Get(p2p.MetaJob)
+	if jobID == "" {
+		writeServerError(s, "", nil, "не указан идентификатор задания")
+		return
+	}
+
+	// Процессоры, на которых задание уже не удалось выполнить
+	exclude := make(map[peer.ID]bool)
+	for _, raw := range strings.Split(f.Get(p2p.MetaExclude), ",") {
+		if id, err := peer.Decode(raw); err == nil {
+			exclude[id] = true
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	group := f.Get(p2p.MetaGroup)
+	for id := range groupPeers(group) {
+		exclude[id] = true
+	}
+	receiverInfo, ok := assign(sender, exclude)
+	if !ok {
+		writeServerError(s, p2p.CodeNoPeer, nil, "нет доступных процессоров")
+		return
+	}
+
+	balance, err := db.Reserve(jobID, sender.String(), receiverInfo.ID.String(), jobCost, group != "")
+	if err != nil {
+		unassign(receiverInfo.ID)
+		if errors.Is(err, db.ErrInsufficientTokens) {
+			fmt.Printf("🪙 У %s недостаточно токенов для задания %s: %d < %d\n", sender, jobID, balance, jobCost)
+			meta := map[string]string{p2p.MetaBalance: strconv.Itoa(balance)}
+			writeServerError(s, p2p.CodeInsufficientTokens, meta,
+				fmt.Sprintf("недостаточно токенов: баланс %d, стоимость задания %d", balance, jobCost))
+			return
+		}
+		if errors.Is(err, db.ErrJobTaken) {
+			fmt.Printf("⛔ Задание %s от %s отклонено: %v\n", jobID, sender, err)
+			writeServerError(s, "", nil, err.Error())
+			return
+		}
+		log.Printf("❌ Ошибка резерва токенов для задания %s: %v\n", jobID, err)
+		writeServerError(s, "", nil, "ошибка резерва токенов")
+		return
+	}
+	if group != "" {
+		joinGroup(group, jobID, receiverInfo.ID)
+	}
+
+	var addrList []string
+	for _, addr := range receiverInfo.Addrs {
+		addrList = append(addrList, addr.String())
+	}
+	meta := map[string]string{
+		p2p.MetaJob:     jobID,
+		p2p.MetaPeer:    receiverInfo.ID.String(),
+		p2p.MetaAddrs:   strings.Join(addrList, ","),
+		p2p.MetaBalance: strconv.Itoa(balance),
+	}
+	if err := frame.Write(s, frame.New(frame.TypePeer, meta, nil)); err != nil {
+		log.Println("❌ Ошибка отправки назначения:", err)
+		return
+	}
+	fmt.Printf("📤 Назначен получатель для %s ➜ %s (задание %s, баланс %d)\n", sender, receiverInfo.ID, jobID, balance)
+}
+
+// Обработчик итогов заданий по протоколу "/job-report/1.0.0".
+// "done" от назначенного процессора — оплата ему резерва, "failed" — возврат резерва инициатору.
+// Для копий задания оплату подтверждает инициатор ("done"), а "disputed" от него
+// возвращает резерв — только если оплата большинства копий группы уже подтверждена
+// (canDispute). "failed" от инициатора после того, как процессор передал копию,
+// проверяется так же и считается оспариванием. Итог учитывается в репутации процессора, см. outcome.
+func handleReport(s network.Stream) {
+	defer s.Close()
+	reporter := s.Conn().RemotePeer()
+
+	f, err := frame.Expect(s, frame.TypeReport)
+	if err != nil {
+		log.Println("⚠️ Ошибка чтения итога задания:", err)
+		return
+	}
+	jobID, status := f.Get(p2p.MetaJob), f.Get(p2p.MetaStatus)
+	if status != p2p.StatusDone && status != p2p.StatusFailed && status != p2p.StatusDisputed {
+		writeServerError(s, "", nil, fmt.Sprintf("неизвестный итог задания %q", status))
+		return
+	}
+
+	lock.Lock()
+	var r *db.Reservation
+	if status != p2p.StatusDisputed {
+		r, err = db.Settle(jobID, reporter.String(), status == p2p.StatusDone)
+		if errors.Is(err, db.ErrDelivered) {
+			status = p2p.StatusDisputed
+		}
+	}
+	if status == p2p.StatusDisputed {
+		if !canDispute(jobID) {
+			lock.Unlock()
+			log.Printf("⚠️ Копия %s от %s не оспорена: большинство копий группы не оплачено\n", jobID, reporter)
+			writeServerError(s, "", nil, "оспорить можно только меньшинство копий, оплата большинства которых подтверждена")
+			return
+		}
+		r, err = db.Dispute(jobID, reporter.String())
+	}
+	if err == nil && r.Verify {
+		recordSettlement(jobID, status)
+	}
+	lock.Unlock()
+	if err != nil {
+		log.Printf("⚠️ Итог задания %s (%s) от %s отклонён: %v\n", jobID, status, reporter, err)
+		writeServerError(s, "", nil, err.Error())
+		return
+	}
+	if status == p2p.StatusDone {
+		fmt.Printf("🪙 Задание %s выполнено: %d ➜ %s\n", jobID, r.Cost, r.Processor)
+	} else {
+		fmt.Printf("🪙 Задание %s не выполнено (%s): %d возвращено %s\n", jobID, f.Payload, r.Cost, r.Initiator)
+	}
+	o := outcome(r, reporter.String(), status, f.Get(p2p.MetaCause))
+	if o == db.OutcomeTimeout {
+		lock.Lock()
+		if !confirmTimeout(r.Processor, reporter.String()) {
+			o = ""
+		}
+		lock.Unlock()
+	}
+	if o != "" {
+		recordOutcome(r.Processor, o)
+	}
+	frame.Write(s, frame.New(frame.TypeAck, map[string]string{p2p.MetaJob: jobID}, nil))
+}
+
+// writeServerError отправляет кадр ошибки с машинным кодом code (может быть пустым)
+func writeServerError(s network.Stream, code string, meta map[string]string, msg string) {
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	if code != "" {
+		meta[p2p.MetaCode] = code
+	}
+	_ = frame.Write(s, frame.New(frame.TypeError, meta, []byte(msg)))
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,31 +15,50 @@ type User struct {
 	Tokens  int
 	Enabled bool
 	Mode    string
+	// Reputation — взвешенная сумма итогов заданий процессора, см. RecordOutcome
+	Reputation int
+	// Счётчики итогов заданий процессора
+	JobsDone     int
+	JobsFailed   int
+	JobsTimedOut int
+	JobsDisputed int
+}
+
+// Итоги заданий процессора для RecordOutcome
+const (
+	OutcomeDone     = "done"     // результат принят и оплачен
+	OutcomeFailed   = "failed"   // процессор сам сообщил об ошибке
+	OutcomeTimeout  = "timeout"  // результат не пришёл инициатору вовремя
+	OutcomeDisputed = "disputed" // результат не совпал с большинством копий
+)
+
+// outcomeColumns — счётчик в users для каждого итога
+var outcomeColumns = map[string]string{
+	OutcomeDone:     "jobs_done",
+	OutcomeFailed:   "jobs_failed",
+	OutcomeTimeout:  "jobs_timed_out",
+	OutcomeDisputed: "jobs_disputed",
 }
 
 var Conn *sql.DB
 
-// Init открывает БД и создаёт таблицу, если нужно.
+// Init открывает БД и применяет недостающие миграции схемы.
 func Init(dsn string) error {
 	var err error
 	Conn, err = sql.Open("sqlite3", dsn)
 	if err != nil {
 		return err
 	}
+	// SQLite допускает одну запись за раз, а база ":memory:" существует только
+	// в пределах одного соединения, поэтому держим ровно одно
+	Conn.SetMaxOpenConns(1)
 	// Ждём, пока БД инициализируется
 	if err := Conn.Ping(); err != nil {
 		return err
 	}
 
-	// Создаём таблицу users
-	_, err = Conn.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            id      INTEGER PRIMARY KEY AUTOINCREMENT,
-            peer_id TEXT    UNIQUE NOT NULL,
-            tokens  INTEGER NOT NULL DEFAULT 0,
-            mode    TEXT    NOT NULL DEFAULT 'turned_off',
-        );
-    `)
+	// Приводим схему к последней версии
+	_, err = Migrate(Conn)
 	return err
 }
 
@@ -57,18 +77,43 @@ func GetUser(peerID string) (*User, error) {
 		return nil, err
 	}
 	row := Conn.QueryRow(
-		`SELECT id, peer_id, tokens, enabled, mode FROM users WHERE peer_id = ?`,
+		`SELECT id, peer_id, tokens, enabled, mode, reputation,
+		        jobs_done, jobs_failed, jobs_timed_out, jobs_disputed
+		 FROM users WHERE peer_id = ?`,
 		peerID,
 	)
 	u := &User{}
 	var enabledInt int
-	if err := row.Scan(&u.ID, &u.PeerID, &u.Tokens, &enabledInt, &u.Mode); err != nil {
+	err := row.Scan(&u.ID, &u.PeerID, &u.Tokens, &enabledInt, &u.Mode, &u.Reputation,
+		&u.JobsDone, &u.JobsFailed, &u.JobsTimedOut, &u.JobsDisputed)
+	if err != nil {
 		return nil, err
 	}
 	u.Enabled = enabledInt != 0
 	return u, nil
 }
 
+// Processors возвращает пиров, которым можно назначать задания: включённых,
+// с ролью "processor" или "all". Один запрос вместо GetUser для каждого пира.
+func Processors() (map[string]bool, error) {
+	rows, err := Conn.Query(
+		`SELECT peer_id FROM users WHERE enabled = 1 AND mode IN ('processor', 'all')`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var peerID string
+		if err := rows.Scan(&peerID); err != nil {
+			return nil, err
+		}
+		ids[peerID] = true
+	}
+	return ids, rows.Err()
+}
+
 // ChangeTokens изменяет баланс токенов на delta (можно отрицательное число).
 // Возвращает новый баланс или ошибку.
 func ChangeTokens(peerID string, delta int) (int, error) {
@@ -95,6 +140,39 @@ func ChangeTokens(peerID string, delta int) (int, error) {
 	return tokens, nil
 }
 
+// RecordOutcome увеличивает счётчик итога outcome у процессора, изменяет его репутацию
+// на delta и возвращает обновлённого пользователя
+func RecordOutcome(peerID, outcome string, delta int) (*User, error) {
+	column, ok := outcomeColumns[outcome]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный итог задания %q", outcome)
+	}
+	if err := ensureRow(peerID); err != nil {
+		return nil, err
+	}
+	_, err := Conn.Exec(
+		`UPDATE users SET `+column+` = `+column+` + 1, reputation = reputation + ? WHERE peer_id = ?`,
+		delta, peerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(peerID)
+}
+
+// Reinstate включает пользователя и обнуляет его репутацию, счётчики итогов сохраняются.
+// Без обнуления первый же неудачный итог снова отключил бы узел.
+func Reinstate(peerID string) error {
+	if err := ensureRow(peerID); err != nil {
+		return err
+	}
+	_, err := Conn.Exec(
+		`UPDATE users SET enabled = 1, reputation = 0 WHERE peer_id = ?`,
+		peerID,
+	)
+	return err
+}
+
 // SetEnabled включает или отключает пользователя
 func SetEnabled(peerID string, enabled bool) error {
 	if err := ensureRow(peerID); err != nil {
@@ -0,0 +1,47 @@
+package db
+
+import "errors"
+
+// ErrTokenUsed — токен допуска уже предъявлен другим узлом
+var ErrTokenUsed = errors.New("токен допуска уже использован другим узлом")
+
+// UseEnrollment отмечает, что токен допуска tokenID предъявил узел peerID.
+// Повторное предъявление тем же узлом допустимо, другим — ErrTokenUsed.
+func UseEnrollment(tokenID, peerID string) error {
+	res, err := Conn.Exec(
+		`INSERT OR IGNORE INTO enrollments(token_id, peer_id) VALUES(?, ?)`,
+		tokenID, peerID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var owner string
+	if err := Conn.QueryRow(`SELECT peer_id FROM enrollments WHERE token_id = ?`, tokenID).Scan(&owner); err != nil {
+		return err
+	}
+	if owner != peerID {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// EnrolledPeers возвращает узлы, допущенные по токенам
+func EnrolledPeers() ([]string, error) {
+	rows, err := Conn.Query(`SELECT DISTINCT peer_id FROM enrollments`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var peers []string
+	for rows.Next() {
+		var peerID string
+		if err := rows.Scan(&peerID); err != nil {
+			return nil, err
+		}
+		peers = append(peers, peerID)
+	}
+	return peers, rows.Err()
+}
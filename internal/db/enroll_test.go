@@ -0,0 +1,27 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUseEnrollment(t *testing.T) {
+	useMemory(t)
+	if err := UseEnrollment("token", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// Тот же узел может зарегистрироваться с тем же токеном повторно, другой — нет
+	if err := UseEnrollment("token", "alice"); err != nil {
+		t.Fatalf("повторная регистрация того же узла: %v", err)
+	}
+	if err := UseEnrollment("token", "bob"); !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("токен принят у второго узла: %v", err)
+	}
+	if err := UseEnrollment("other", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := EnrolledPeers()
+	if err != nil || len(peers) != 2 {
+		t.Fatalf("допущенные узлы %v (%v), ожидались alice и bob", peers, err)
+	}
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Причины движения токенов в ledger
+const (
+	ReasonGrant    = "grant"    // начисление при первой регистрации
+	ReasonReserve  = "reserve"  // резерв с баланса инициатора при назначении процессора
+	ReasonVerify   = "verify"   // резерв задания, которое оплачивается только после проверки инициатором
+	ReasonReassign = "reassign" // задание с открытым резервом назначено другому процессору
+	ReasonCredit   = "credit"   // оплата процессору за выполненное задание
+	ReasonRefund   = "refund"   // возврат резерва инициатору при ошибке
+	ReasonLegacy   = "legacy"   // оплата задания протокола 1.0.0 при назначении: без резерва и возврата
+	// ReasonDelivered — процессор сообщил, что передал результат задания с проверкой (без движения токенов)
+	ReasonDelivered = "delivered"
+)
+
+var (
+	ErrInsufficientTokens = errors.New("INSUFFICIENT_TOKENS")
+	ErrNoReservation      = errors.New("нет открытого резерва для задания")
+	ErrNotAssigned        = errors.New("задание назначено другому процессору")
+	ErrAwaitingVerify     = errors.New("задание оплачивается после проверки результата инициатором")
+	ErrJobTaken           = errors.New("у задания с таким идентификатором открыт резерв другого инициатора")
+	ErrDelivered          = errors.New("результат задания уже передан инициатору: резерв возвращается, только если копия оспорена")
+)
+
+// LedgerEntry — одна строка журнала движения токенов
+type LedgerEntry struct {
+	ID           int
+	JobID        string
+	PeerID       string // чей баланс изменился
+	Delta        int
+	Reason       string
+	Counterparty string // вторая сторона: процессор для резерва, инициатор для оплаты
+	CreatedAt    time.Time
+}
+
+// Reservation — открытый резерв токенов под задание
+type Reservation struct {
+	JobID     string
+	Initiator string
+	Processor string
+	Cost      int
+	Verify    bool // оплату подтверждает инициатор, а не процессор
+	Delivered bool // процессор сообщил, что передал результат (только для Verify)
+}
+
+// querier — общее у *sql.DB и *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// appendLedger добавляет строку в журнал. Строки журнала никогда не изменяются и не удаляются.
+func appendLedger(q querier, jobID, peerID string, delta int, reason, counterparty string) error {
+	_, err := q.Exec(
+		`INSERT INTO ledger(job_id, peer_id, delta, reason, counterparty) VALUES(?, ?, ?, ?, ?)`,
+		jobID, peerID, delta, reason, counterparty,
+	)
+	return err
+}
+
+// openReservation восстанавливает открытый резерв задания по журналу
+func openReservation(q querier, jobID string) (*Reservation, error) {
+	rows, err := q.Query(
+		`SELECT peer_id, delta, reason, counterparty FROM ledger WHERE job_id = ? ORDER BY id`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var open *Reservation
+	for rows.Next() {
+		var peerID, reason, counterparty string
+		var delta int
+		if err := rows.Scan(&peerID, &delta, &reason, &counterparty); err != nil {
+			return nil, err
+		}
+		switch reason {
+		case ReasonReserve, ReasonVerify:
+			open = &Reservation{JobID: jobID, Initiator: peerID, Processor: counterparty, Cost: -delta, Verify: reason == ReasonVerify}
+		case ReasonReassign:
+			if open != nil && peerID == open.Initiator {
+				open.Processor, open.Delivered = counterparty, false
+			}
+		case ReasonDelivered:
+			if open != nil && peerID == open.Processor {
+				open.Delivered = true
+			}
+		case ReasonCredit, ReasonRefund:
+			open = nil
+		}
+	}
+	return open, rows.Err()
+}
+
+// Reserve списывает cost токенов с баланса инициатора под задание jobID, назначенное processor.
+// Если у задания уже есть открытый резерв этого инициатора (повторное назначение),
+// повторно токены не списываются — резерв переходит к новому процессору.
+// Идентификатор задания выбирает инициатор, поэтому задание с открытым резервом другого
+// инициатора отклоняется (ErrJobTaken): иначе итог по нему закрыл бы чужой резерв.
+// verify — задание выполняется избыточно, оплату подтверждает инициатор после сверки результатов.
+func Reserve(jobID, initiator, processor string, cost int, verify bool) (int, error) {
+	if err := ensureRow(initiator); err != nil {
+		return 0, err
+	}
+	tx, err := Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	open, err := openReservation(tx, jobID)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case open != nil && open.Initiator != initiator:
+		return 0, ErrJobTaken
+	case open != nil:
+		if err := appendLedger(tx, jobID, initiator, 0, ReasonReassign, processor); err != nil {
+			return 0, err
+		}
+	default:
+		var balance int
+		if err := tx.QueryRow(`SELECT tokens FROM users WHERE peer_id = ?`, initiator).Scan(&balance); err != nil {
+			return 0, err
+		}
+		if balance < cost {
+			return balance, ErrInsufficientTokens
+		}
+		if _, err := tx.Exec(`UPDATE users SET tokens = tokens - ? WHERE peer_id = ?`, cost, initiator); err != nil {
+			return 0, err
+		}
+		reason := ReasonReserve
+		if verify {
+			reason = ReasonVerify
+		}
+		if err := appendLedger(tx, jobID, initiator, -cost, reason, processor); err != nil {
+			return 0, err
+		}
+	}
+
+	var balance int
+	if err := tx.QueryRow(`SELECT tokens FROM users WHERE peer_id = ?`, initiator).Scan(&balance); err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+// Settle закрывает резерв задания. success — оплата процессору (сообщить может только он сам,
+// а для резерва с проверкой — только инициатор), иначе — возврат инициатору
+// (сообщить может инициатор или назначенный процессор).
+// Об успехе задания с проверкой процессор сообщает, когда передал результат: резерв остаётся
+// открытым (ErrAwaitingVerify), но вернуть его инициатор после этого может только через Dispute —
+// иначе он получал бы результат копии бесплатно (ErrDelivered).
+func Settle(jobID, reporter string, success bool) (*Reservation, error) {
+	return settle(jobID, reporter, success, false)
+}
+
+// Dispute возвращает инициатору резерв копии, результат которой не совпал с большинством,
+// даже если процессор уже передал результат. Сколько копий группы можно оспорить,
+// решает вызывающий: в журнале группы копий не видно.
+func Dispute(jobID, initiator string) (*Reservation, error) {
+	return settle(jobID, initiator, false, true)
+}
+
+func settle(jobID, reporter string, success, dispute bool) (*Reservation, error) {
+	tx, err := Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	open, err := openReservation(tx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if open == nil {
+		return nil, ErrNoReservation
+	}
+
+	if success {
+		approver := open.Processor
+		if open.Verify {
+			approver = open.Initiator
+		}
+		if reporter != approver {
+			if reporter != open.Processor {
+				return nil, ErrNotAssigned
+			}
+			if !open.Delivered {
+				if err := appendLedger(tx, jobID, open.Processor, 0, ReasonDelivered, open.Initiator); err != nil {
+					return nil, err
+				}
+				if err := tx.Commit(); err != nil {
+					return nil, err
+				}
+			}
+			return nil, ErrAwaitingVerify
+		}
+		if err := creditTx(tx, open.Processor, open.Cost); err != nil {
+			return nil, err
+		}
+		err = appendLedger(tx, jobID, open.Processor, open.Cost, ReasonCredit, open.Initiator)
+	} else {
+		switch {
+		case dispute && reporter != open.Initiator:
+			return nil, ErrNotAssigned
+		case reporter != open.Processor && reporter != open.Initiator:
+			return nil, ErrNotAssigned
+		case !dispute && open.Delivered && reporter == open.Initiator:
+			return nil, ErrDelivered
+		}
+		if err := creditTx(tx, open.Initiator, open.Cost); err != nil {
+			return nil, err
+		}
+		err = appendLedger(tx, jobID, open.Initiator, open.Cost, ReasonRefund, open.Processor)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return open, tx.Commit()
+}
+
+// ChargeLegacy оплачивает процессору processor задание инициатора по протоколу 1.0.0.
+// В этом протоколе нет ни идентификатора задания в запросе, ни итогов, поэтому резерв
+// закрыть было бы нечем: стоимость переводится сразу при назначении и не возвращается.
+// jobID — идентификатор, под которым перевод записывается в журнал.
+func ChargeLegacy(jobID, initiator, processor string, cost int) (int, error) {
+	if err := ensureRow(initiator); err != nil {
+		return 0, err
+	}
+	tx, err := Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var balance int
+	if err := tx.QueryRow(`SELECT tokens FROM users WHERE peer_id = ?`, initiator).Scan(&balance); err != nil {
+		return 0, err
+	}
+	if balance < cost {
+		return balance, ErrInsufficientTokens
+	}
+	if _, err := tx.Exec(`UPDATE users SET tokens = tokens - ? WHERE peer_id = ?`, cost, initiator); err != nil {
+		return 0, err
+	}
+	if err := appendLedger(tx, jobID, initiator, -cost, ReasonLegacy, processor); err != nil {
+		return 0, err
+	}
+	if err := creditTx(tx, processor, cost); err != nil {
+		return 0, err
+	}
+	if err := appendLedger(tx, jobID, processor, cost, ReasonLegacy, initiator); err != nil {
+		return 0, err
+	}
+	return balance - cost, tx.Commit()
+}
+
+// GrantOnce начисляет amount токенов пиру, если он ещё ни разу не получал начисления.
+// Возвращает true, если начисление произошло.
+func GrantOnce(peerID string, amount int) (bool, error) {
+	if err := ensureRow(peerID); err != nil {
+		return false, err
+	}
+	tx, err := Conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var granted int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM ledger WHERE peer_id = ? AND reason = ?`,
+		peerID, ReasonGrant,
+	).Scan(&granted)
+	if err != nil {
+		return false, err
+	}
+	if granted > 0 {
+		return false, nil
+	}
+	if err := creditTx(tx, peerID, amount); err != nil {
+		return false, err
+	}
+	if err := appendLedger(tx, "", peerID, amount, ReasonGrant, ""); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// JobLedger возвращает все движения токенов по заданию
+func JobLedger(jobID string) ([]LedgerEntry, error) {
+	rows, err := Conn.Query(
+		`SELECT id, job_id, peer_id, delta, reason, counterparty, created_at
+		 FROM ledger WHERE job_id = ? ORDER BY id`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.ID, &e.JobID, &e.PeerID, &e.Delta, &e.Reason, &e.Counterparty, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func creditTx(tx *sql.Tx, peerID string, amount int) error {
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO users(peer_id) VALUES(?)`, peerID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE users SET tokens = tokens + ? WHERE peer_id = ?`, amount, peerID)
+	return err
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// useMemory подменяет Conn пустой базой в памяти с последней схемой
+func useMemory(t *testing.T) {
+	t.Helper()
+	conn := openMemory(t)
+	if _, err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	prev := Conn
+	Conn = conn
+	t.Cleanup(func() { Conn = prev })
+}
+
+// grant начисляет пиру стартовые токены
+func grant(t *testing.T, peerID string, amount int) {
+	t.Helper()
+	if ok, err := GrantOnce(peerID, amount); err != nil || !ok {
+		t.Fatalf("начисление %s: %v, %v", peerID, ok, err)
+	}
+}
+
+// balance возвращает баланс пира
+func balance(t *testing.T, peerID string) int {
+	t.Helper()
+	u, err := GetUser(peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Tokens
+}
+
+// reserve резервирует токены под задание или завершает тест
+func reserve(t *testing.T, jobID, initiator, processor string, cost int, verify bool) int {
+	t.Helper()
+	left, err := Reserve(jobID, initiator, processor, cost, verify)
+	if err != nil {
+		t.Fatalf("резерв %s: %v", jobID, err)
+	}
+	return left
+}
+
+func TestGrantOnce(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	if ok, err := GrantOnce("init", 10); err != nil || ok {
+		t.Fatalf("повторное начисление: %v, %v", ok, err)
+	}
+	if got := balance(t, "init"); got != 10 {
+		t.Fatalf("баланс %d, ожидалось 10", got)
+	}
+}
+
+func TestReserveInsufficientTokens(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 1)
+	left, err := Reserve("job", "init", "proc", 2, false)
+	if !errors.Is(err, ErrInsufficientTokens) || left != 1 {
+		t.Fatalf("резерв сверх баланса: %d, %v", left, err)
+	}
+	if got := balance(t, "init"); got != 1 {
+		t.Fatalf("баланс %d после отказа, ожидалось 1", got)
+	}
+	if entries, err := JobLedger("job"); err != nil || len(entries) != 0 {
+		t.Fatalf("в журнале %v (%v), ожидалось пусто", entries, err)
+	}
+	if _, err := Settle("job", "proc", true); !errors.Is(err, ErrNoReservation) {
+		t.Fatalf("оплата без резерва: %v", err)
+	}
+}
+
+func TestReserveReassignDoesNotChargeTwice(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	if left := reserve(t, "job", "init", "first", 3, false); left != 7 {
+		t.Fatalf("баланс после резерва %d, ожидалось 7", left)
+	}
+	if left := reserve(t, "job", "init", "second", 3, false); left != 7 {
+		t.Fatalf("повторное назначение списало токены: баланс %d", left)
+	}
+
+	// Оплату получает только процессор последнего назначения
+	if _, err := Settle("job", "first", true); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("оплата прежнему процессору: %v", err)
+	}
+	r, err := Settle("job", "second", true)
+	if err != nil || r.Processor != "second" || r.Cost != 3 {
+		t.Fatalf("оплата: %+v, %v", r, err)
+	}
+	if got := balance(t, "second"); got != 3 {
+		t.Fatalf("баланс процессора %d, ожидалось 3", got)
+	}
+	if got := balance(t, "init"); got != 7 {
+		t.Fatalf("баланс инициатора %d, ожидалось 7", got)
+	}
+	if _, err := Settle("job", "init", false); !errors.Is(err, ErrNoReservation) {
+		t.Fatalf("возврат закрытого резерва: %v", err)
+	}
+}
+
+func TestSettleWrongReporter(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	reserve(t, "job", "init", "proc", 2, false)
+
+	if _, err := Settle("job", "init", true); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("оплата по отчёту инициатора: %v", err)
+	}
+	if _, err := Settle("job", "stranger", false); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("возврат по отчёту постороннего: %v", err)
+	}
+	if _, err := Settle("job", "stranger", true); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("оплата по отчёту постороннего: %v", err)
+	}
+	if _, err := Settle("job", "proc", false); err != nil {
+		t.Fatalf("возврат по отчёту процессора: %v", err)
+	}
+	if got := balance(t, "init"); got != 10 {
+		t.Fatalf("баланс после возврата %d, ожидалось 10", got)
+	}
+}
+
+func TestSettleVerifyReservation(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	reserve(t, "job", "init", "proc", 2, true)
+
+	// Копию задания оплачивает инициатор после сверки, а не сам процессор
+	if _, err := Settle("job", "proc", true); !errors.Is(err, ErrAwaitingVerify) {
+		t.Fatalf("оплата копии по отчёту процессора: %v", err)
+	}
+	r, err := Settle("job", "init", true)
+	if err != nil || !r.Verify || r.Processor != "proc" {
+		t.Fatalf("оплата копии: %+v, %v", r, err)
+	}
+	if got := balance(t, "proc"); got != 2 {
+		t.Fatalf("баланс процессора %d, ожидалось 2", got)
+	}
+}
+
+func TestReserveRejectsForeignJobID(t *testing.T) {
+	useMemory(t)
+	grant(t, "alice", 10)
+	grant(t, "bob", 10)
+	reserve(t, "job", "alice", "proc", 2, false)
+
+	if _, err := Reserve("job", "bob", "other", 2, false); !errors.Is(err, ErrJobTaken) {
+		t.Fatalf("резерв чужого задания: %v", err)
+	}
+	if got := balance(t, "bob"); got != 10 {
+		t.Fatalf("баланс второго инициатора %d, ожидалось 10", got)
+	}
+	// Резерв первого инициатора не перешёл к другому процессору
+	r, err := Settle("job", "alice", false)
+	if err != nil || r.Initiator != "alice" || r.Processor != "proc" {
+		t.Fatalf("возврат: %+v, %v", r, err)
+	}
+	if got := balance(t, "alice"); got != 10 {
+		t.Fatalf("баланс первого инициатора %d, ожидалось 10", got)
+	}
+
+	// После закрытия резерва идентификатор свободен
+	if left := reserve(t, "job", "bob", "other", 2, false); left != 8 {
+		t.Fatalf("баланс второго инициатора %d, ожидалось 8", left)
+	}
+}
+
+func TestChargeLegacy(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 3)
+	left, err := ChargeLegacy("v1-a", "init", "proc", 2)
+	if err != nil || left != 1 {
+		t.Fatalf("оплата: %d, %v", left, err)
+	}
+	if got := balance(t, "proc"); got != 2 {
+		t.Fatalf("баланс процессора %d, ожидалось 2", got)
+	}
+	if left, err := ChargeLegacy("v1-b", "init", "proc", 2); !errors.Is(err, ErrInsufficientTokens) || left != 1 {
+		t.Fatalf("оплата сверх баланса: %d, %v", left, err)
+	}
+	// Перевод не оставляет резерва, который можно было бы вернуть
+	if _, err := Settle("v1-a", "init", false); !errors.Is(err, ErrNoReservation) {
+		t.Fatalf("возврат оплаты протокола 1.0.0: %v", err)
+	}
+}
+
+func TestSettleAfterDelivery(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	reserve(t, "job", "init", "proc", 2, true)
+
+	// До передачи результата инициатор может вернуть резерв
+	if _, err := Settle("job", "proc", true); !errors.Is(err, ErrAwaitingVerify) {
+		t.Fatalf("отчёт процессора о передаче: %v", err)
+	}
+	// После передачи — только оспорив копию
+	if _, err := Settle("job", "init", false); !errors.Is(err, ErrDelivered) {
+		t.Fatalf("возврат после передачи результата: %v", err)
+	}
+	if _, err := Dispute("job", "proc"); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("оспаривание процессором: %v", err)
+	}
+	r, err := Dispute("job", "init")
+	if err != nil || r.Initiator != "init" || !r.Delivered {
+		t.Fatalf("оспаривание: %+v, %v", r, err)
+	}
+	if got := balance(t, "init"); got != 10 {
+		t.Fatalf("баланс после оспаривания %d, ожидалось 10", got)
+	}
+}
+
+func TestDeliveryResetOnReassign(t *testing.T) {
+	useMemory(t)
+	grant(t, "init", 10)
+	reserve(t, "job", "init", "first", 2, true)
+	if _, err := Settle("job", "first", true); !errors.Is(err, ErrAwaitingVerify) {
+		t.Fatalf("отчёт процессора о передаче: %v", err)
+	}
+	// Новый процессор ещё ничего не передал: возврат возможен
+	reserve(t, "job", "init", "second", 2, true)
+	if _, err := Settle("job", "init", false); err != nil {
+		t.Fatalf("возврат после повторного назначения: %v", err)
+	}
+}
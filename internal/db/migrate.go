@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles — SQL-миграции схемы. Имя файла: "<версия>_<описание>.sql",
+// версии применяются по возрастанию, каждая ровно один раз.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration — одна версия схемы
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations читает миграции из каталога migrations в fsys и сортирует их по версии
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var list []Migration
+	seen := make(map[int]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("миграция %s: имя должно начинаться с номера версии", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("миграции %s и %s имеют одну версию %d", other, name, version)
+		}
+		seen[version] = name
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrate приводит схему conn к последней версии и возвращает номера применённых миграций.
+// Каждая миграция выполняется в своей транзакции вместе с записью в schema_migrations,
+// поэтому при ошибке база остаётся на последней успешно применённой версии.
+func Migrate(conn *sql.DB) ([]int, error) {
+	return migrate(conn, migrationFiles)
+}
+
+func migrate(conn *sql.DB, fsys fs.FS) ([]int, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if err := baseline(conn); err != nil {
+		return nil, fmt.Errorf("подготовка schema_migrations: %w", err)
+	}
+	done, err := appliedVersions(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if done[m.Version] {
+			continue
+		}
+		if err := apply(conn, m); err != nil {
+			return applied, fmt.Errorf("миграция %s: %w", m.Name, err)
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+func apply(conn *sql.DB, m Migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations(version, name) VALUES(?, ?)`, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// baseline создаёт schema_migrations. Если база создана до появления миграций
+// (старые tokens.db), уже существующие части схемы отмечаются применёнными,
+// чтобы миграции не пытались создать их повторно.
+func baseline(conn *sql.DB) error {
+	exists, err := tableExists(conn, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+        CREATE TABLE schema_migrations (
+            version    INTEGER PRIMARY KEY,
+            name       TEXT     NOT NULL,
+            applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	mark := func(version int, name string) error {
+		_, err := tx.Exec(`INSERT INTO schema_migrations(version, name) VALUES(?, ?)`, version, name+" (baseline)")
+		return err
+	}
+	users, err := tableExists(tx, "users")
+	if err != nil {
+		return err
+	}
+	if users {
+		if err := mark(1, "0001_users"); err != nil {
+			return err
+		}
+		enabled, err := columnExists(tx, "users", "enabled")
+		if err != nil {
+			return err
+		}
+		if enabled {
+			if err := mark(2, "0002_users_enabled"); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion возвращает последнюю применённую версию схемы (0 — миграций не было)
+func SchemaVersion(conn *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := conn.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	return int(version.Int64), err
+}
+
+func appliedVersions(conn *sql.DB) (map[int]bool, error) {
+	rows, err := conn.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		done[v] = true
+	}
+	return done, rows.Err()
+}
+
+func tableExists(q querier, table string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	return n > 0, err
+}
+
+func columnExists(q querier, table, column string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	return n > 0, err
+}
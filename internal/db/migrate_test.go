@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+)
+
+// openMemory открывает пустую базу в памяти. Одно соединение — иначе у каждого своя база.
+func openMemory(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func latestVersion(t *testing.T) int {
+	t.Helper()
+	list, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list[len(list)-1].Version
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	conn := openMemory(t)
+	applied, err := Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != latestVersion(t) {
+		t.Fatalf("применено %v, ожидались все %d миграций", applied, latestVersion(t))
+	}
+	version, err := SchemaVersion(conn)
+	if err != nil || version != latestVersion(t) {
+		t.Fatalf("версия схемы %d (%v), ожидалась %d", version, err, latestVersion(t))
+	}
+	for _, col := range []string{"peer_id", "tokens", "mode", "enabled"} {
+		if ok, err := columnExists(conn, "users", col); err != nil || !ok {
+			t.Errorf("в users нет колонки %s (%v)", col, err)
+		}
+	}
+	if ok, err := tableExists(conn, "ledger"); err != nil || !ok {
+		t.Errorf("таблица ledger не создана (%v)", err)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	conn := openMemory(t)
+	if _, err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("повторный запуск применил %v", applied)
+	}
+}
+
+// База, созданная до миграций: users без enabled и без schema_migrations
+func TestMigrateLegacyDatabase(t *testing.T) {
+	conn := openMemory(t)
+	_, err := conn.Exec(`
+        CREATE TABLE users (
+            id      INTEGER PRIMARY KEY AUTOINCREMENT,
+            peer_id TEXT    UNIQUE NOT NULL,
+            tokens  INTEGER NOT NULL DEFAULT 0,
+            mode    TEXT    NOT NULL DEFAULT 'turned_off'
+        );
+        INSERT INTO users(peer_id, tokens, mode) VALUES('peer-a', 7, 'processor');
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 || applied[0] != 2 {
+		t.Fatalf("применено %v, ожидалось начиная с версии 2", applied)
+	}
+
+	var tokens, enabled int
+	var mode string
+	err = conn.QueryRow(`SELECT tokens, enabled, mode FROM users WHERE peer_id = 'peer-a'`).Scan(&tokens, &enabled, &mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 7 || enabled != 1 || mode != "processor" {
+		t.Fatalf("данные пользователя изменились: tokens=%d enabled=%d mode=%s", tokens, enabled, mode)
+	}
+}
+
+// Неудачная миграция откатывается целиком и не отмечается применённой
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001_a.sql": {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+		"migrations/0002_b.sql": {Data: []byte(`CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES(1);`)},
+	}
+	conn := openMemory(t)
+	applied, err := migrate(conn, fsys)
+	if err == nil {
+		t.Fatal("ожидалась ошибка миграции 0002")
+	}
+	if len(applied) != 1 || applied[0] != 1 {
+		t.Fatalf("применено %v, ожидалось [1]", applied)
+	}
+	if version, _ := SchemaVersion(conn); version != 1 {
+		t.Fatalf("версия схемы %d, ожидалась 1", version)
+	}
+	if ok, _ := tableExists(conn, "b"); ok {
+		t.Fatal("таблица из неудачной миграции осталась в базе")
+	}
+}
+
+func TestLoadMigrationsOrderAndNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_c.sql": {Data: []byte(`SELECT 1;`)},
+		"migrations/0002_b.sql": {Data: []byte(`SELECT 1;`)},
+		"migrations/0001_a.sql": {Data: []byte(`SELECT 1;`)},
+	}
+	list, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, m := range list {
+		got = append(got, m.Version)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 10 {
+		t.Fatalf("порядок миграций %v", got)
+	}
+
+	bad := []fstest.MapFS{
+		{"migrations/users.sql": {Data: []byte(`SELECT 1;`)}},
+		{
+			"migrations/0001_a.sql": {Data: []byte(`SELECT 1;`)},
+			"migrations/0001_b.sql": {Data: []byte(`SELECT 1;`)},
+		},
+	}
+	for _, fsys := range bad {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("ожидалась ошибка для %v", fsys)
+		}
+	}
+}
+
+// После Init работают запросы, которые раньше падали из-за отсутствия enabled
+func TestInitUserQueries(t *testing.T) {
+	if err := Init(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Conn.Close() })
+
+	u, err := GetUser("peer-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Enabled || u.Tokens != 0 {
+		t.Fatalf("новый пользователь: %+v", u)
+	}
+	if err := SetEnabled("peer-b", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMode("peer-b", "all"); err != nil {
+		t.Fatal(err)
+	}
+	u, err = GetUser("peer-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Enabled || u.Mode != "all" {
+		t.Fatalf("изменения не сохранились: %+v", u)
+	}
+}
@@ -0,0 +1,7 @@
+-- Пользователи сети: баланс токенов и роль узла
+CREATE TABLE IF NOT EXISTS users (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    peer_id TEXT    UNIQUE NOT NULL,
+    tokens  INTEGER NOT NULL DEFAULT 0,
+    mode    TEXT    NOT NULL DEFAULT 'turned_off'
+);
@@ -0,0 +1,2 @@
+-- Флаг включения узла: отключённым узлам задания не назначаются
+ALTER TABLE users ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1;
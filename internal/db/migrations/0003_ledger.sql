@@ -0,0 +1,11 @@
+-- Журнал движения токенов (строки только добавляются)
+CREATE TABLE IF NOT EXISTS ledger (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id       TEXT     NOT NULL,
+    peer_id      TEXT     NOT NULL,
+    delta        INTEGER  NOT NULL,
+    reason       TEXT     NOT NULL,
+    counterparty TEXT     NOT NULL DEFAULT '',
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS ledger_job ON ledger(job_id);
@@ -0,0 +1,3 @@
+-- Репутация процессора: растёт, когда его результат совпал с большинством при
+-- избыточном выполнении, и падает, когда инициатор оспорил результат
+ALTER TABLE users ADD COLUMN reputation INTEGER NOT NULL DEFAULT 0;
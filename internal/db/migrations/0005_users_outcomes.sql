@@ -0,0 +1,5 @@
+-- Счётчики итогов заданий процессора, из которых складывается его репутация
+ALTER TABLE users ADD COLUMN jobs_done INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN jobs_failed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN jobs_timed_out INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN jobs_disputed INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Использованные токены допуска закрытого режима: каждый токен допускает один узел,
+-- а допущенные узлы остаются допущенными после перезапуска сервера
+CREATE TABLE IF NOT EXISTS enrollments (
+    token_id   TEXT     PRIMARY KEY,
+    peer_id    TEXT     NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS enrollments_peer ON enrollments(peer_id);
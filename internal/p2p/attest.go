@@ -0,0 +1,181 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/style"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p/core/crypto"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// attestDomain отделяет подпись результата от других подписей ключом узла
+const attestDomain = "coursework-result-attestation:"
+
+// Attestation — описание результата задания, которое процессор подписывает своим ключом libp2p
+type Attestation struct {
+	JobID      string       `json:"job_id"`
+	Processor  string       `json:"processor"`   // ID процессора, выполнившего задание
+	InputHash  string       `json:"input_hash"`  // SHA-256 полученного изображения (до шифрования)
+	StyleHash  string       `json:"style_hash"`  // SHA-256 файла признаков стиля
+	Params     style.Params `json:"params"`      // параметры, с которыми выполнена стилизация
+	OutputHash string       `json:"output_hash"` // SHA-256 результата (до шифрования)
+	Created    time.Time    `json:"created"`
+}
+
+// Envelope — данные, подписанные ключом узла: описание результата или запись сервиса в DHT.
+// Signature — подпись домена и Payload ключом PublicKey (формат libp2p).
+type Envelope struct {
+	Payload   []byte `json:"payload"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// signEnvelope подписывает payload ключом узла; domain отделяет назначения подписей
+func signEnvelope(key crypto.PrivKey, domain string, payload []byte) (Envelope, error) {
+	pub, err := crypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return Envelope{}, err
+	}
+	sig, err := key.Sign(append([]byte(domain), payload...))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Payload: payload, PublicKey: pub, Signature: sig}, nil
+}
+
+// verify проверяет, что конверт подписан для domain ключом узла signer
+func (e Envelope) verify(domain string, signer peerstore.ID) error {
+	pub, err := crypto.UnmarshalPublicKey(e.PublicKey)
+	if err != nil {
+		return fmt.Errorf("неверный ключ подписи: %w", err)
+	}
+	id, err := peerstore.IDFromPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if id != signer {
+		return fmt.Errorf("данные подписаны %s, а ожидалась подпись %s", id, signer)
+	}
+	valid, err := pub.Verify(append([]byte(domain), e.Payload...), e.Signature)
+	if err != nil || !valid {
+		return errors.New("подпись недействительна")
+	}
+	return nil
+}
+
+// SignAttestation подписывает описание результата ключом процессора
+func SignAttestation(key crypto.PrivKey, a Attestation) (Envelope, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return signEnvelope(key, attestDomain, payload)
+}
+
+// Open проверяет подпись и что её ключ принадлежит процессору from, и возвращает описание результата
+func (e Envelope) Open(from peerstore.ID) (Attestation, error) {
+	if err := e.verify(attestDomain, from); err != nil {
+		return Attestation{}, fmt.Errorf("подпись результата: %w", err)
+	}
+	var a Attestation
+	if err := json.Unmarshal(e.Payload, &a); err != nil {
+		return Attestation{}, fmt.Errorf("неверное описание результата: %w", err)
+	}
+	if a.Processor != from.String() {
+		return Attestation{}, fmt.Errorf("описание результата выдано для процессора %s", a.Processor)
+	}
+	return a, nil
+}
+
+// Provenance — происхождение сохранённого результата: подписи процессоров, выполнивших задание
+type Provenance struct {
+	Output     string     `json:"output"`      // имя файла результата
+	OutputHash string     `json:"output_hash"` // SHA-256 файла результата
+	Envelopes  []Envelope `json:"envelopes"`   // у склеенного из фрагментов изображения — по одной на фрагмент
+}
+
+// ProvenancePath — файл происхождения рядом с результатом
+func ProvenancePath(result string) string {
+	return result + ".provenance.json"
+}
+
+// WriteProvenance сохраняет происхождение результата result в ProvenancePath(result)
+func WriteProvenance(result string, p Provenance) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ProvenancePath(result), append(data, '\n'), 0644)
+}
+
+// saveProvenance сохраняет подпись env рядом с результатом result
+func saveProvenance(result string, env Envelope) error {
+	hash, err := style.HashFile(result)
+	if err != nil {
+		return err
+	}
+	return WriteProvenance(result, Provenance{Output: filepath.Base(result), OutputHash: hash, Envelopes: []Envelope{env}})
+}
+
+// CombineProvenance сохраняет для result, собранного из результатов parts (фрагментов или
+// принятой копии), подписи этих результатов. Результаты без подписи пропускаются.
+func CombineProvenance(result string, parts []string) error {
+	var envelopes []Envelope
+	for _, part := range parts {
+		p, ok, err := ReadProvenance(part)
+		if err != nil {
+			return err
+		}
+		if ok {
+			envelopes = append(envelopes, p.Envelopes...)
+		}
+	}
+	if len(envelopes) == 0 {
+		os.Remove(ProvenancePath(result))
+		return nil
+	}
+	hash, err := style.HashFile(result)
+	if err != nil {
+		return err
+	}
+	return WriteProvenance(result, Provenance{Output: filepath.Base(result), OutputHash: hash, Envelopes: envelopes})
+}
+
+// ReadProvenance читает происхождение результата result; отсутствие файла — не ошибка
+func ReadProvenance(result string) (Provenance, bool, error) {
+	data, err := os.ReadFile(ProvenancePath(result))
+	if os.IsNotExist(err) {
+		return Provenance{}, false, nil
+	}
+	if err != nil {
+		return Provenance{}, false, err
+	}
+	var p Provenance
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Provenance{}, false, fmt.Errorf("чтение %s: %w", ProvenancePath(result), err)
+	}
+	return p, true, nil
+}
+
+// checkAttestation сверяет подписанное описание с тем, что инициатор отправил (sent)
+// и получил (outputHash). Параметры, которые инициатор не задавал, процессор выбирает сам.
+func checkAttestation(a, sent Attestation, outputHash string) error {
+	switch {
+	case a.JobID != sent.JobID:
+		return fmt.Errorf("подпись выдана для задания %s", a.JobID)
+	case a.InputHash != sent.InputHash:
+		return errors.New("подписанный хэш изображения не совпадает с отправленным")
+	case sent.StyleHash != "" && a.StyleHash != sent.StyleHash:
+		return errors.New("подписанный хэш стиля не совпадает с отправленным")
+	case sent.Params.Or(a.Params) != a.Params:
+		return fmt.Errorf("подписанные параметры %s не совпадают с запрошенными %s", a.Params, sent.Params)
+	case a.OutputHash != outputHash:
+		return errors.New("подписанный хэш результата не совпадает с полученным")
+	}
+	return nil
+}
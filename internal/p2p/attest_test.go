@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/style"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p/core/crypto"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newPeerKey создаёт ключ узла и его ID
+func newPeerKey(t *testing.T) (crypto.PrivKey, peerstore.ID) {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peerstore.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, id
+}
+
+// resultFrame собирает кадр результата с подписью env; nil — без подписи
+func resultFrame(t *testing.T, jobID string, payload []byte, env *Envelope) *frame.Frame {
+	t.Helper()
+	meta := map[string]string{MetaJob: jobID}
+	if env != nil {
+		raw, err := json.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta[MetaEnvelope] = string(raw)
+	}
+	return frame.New(frame.TypeResult, meta, payload)
+}
+
+func TestOpenResult(t *testing.T) {
+	procKey, proc := newPeerKey(t)
+	otherKey, other := newPeerKey(t)
+	input, result := []byte("input image"), []byte("stylized result")
+	params := style.Params{Epochs: 50, Size: 256}
+
+	jobs := NewJobTable()
+	job := jobs.Add("in/cat.jpg", "style.jpg", "out")
+	sent := Attestation{JobID: job.ID, InputHash: style.Hash(input), StyleHash: "style-hash", Params: params}
+	jobs.expect(job.ID, sent)
+
+	// attest — описание результата, которое подписал бы честный процессор
+	attest := func() Attestation {
+		a := sent
+		a.Processor = proc.String()
+		a.Params = params.Or(style.DefaultParams)
+		a.OutputHash = style.Hash(result)
+		a.Created = time.Now()
+		return a
+	}
+	sign := func(key crypto.PrivKey, a Attestation) *Envelope {
+		env, err := SignAttestation(key, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &env
+	}
+
+	tests := []struct {
+		name    string
+		from    peerstore.ID
+		payload []byte
+		env     *Envelope
+		wantErr string // пусто — результат принят
+	}{
+		{"действительная подпись", proc, result, sign(procKey, attest()), ""},
+		{"изменённый результат", proc, []byte("other result"), sign(procKey, attest()), "хэш результата"},
+		{"подпись другого узла", proc, result, sign(otherKey, attest()), "ожидалась подпись"},
+		{"подпись другого узла от его имени", other, result, sign(otherKey, attest()), "выдано для процессора"},
+		{"другой стиль", proc, result, sign(procKey, func() Attestation { a := attest(); a.StyleHash = "other"; return a }()), "хэш стиля"},
+		{"другие параметры", proc, result, sign(procKey, func() Attestation { a := attest(); a.Params.Epochs = 10; return a }()), "параметры"},
+		{"другое изображение", proc, result, sign(procKey, func() Attestation { a := attest(); a.InputHash = "other"; return a }()), "хэш изображения"},
+		{"другое задание", proc, result, sign(procKey, func() Attestation { a := attest(); a.JobID = "other"; return a }()), "для задания"},
+		{"изменённое описание", proc, result, func() *Envelope {
+			env := sign(procKey, attest())
+			env.Payload = []byte(strings.Replace(string(env.Payload), `"epochs":50`, `"epochs":51`, 1))
+			return env
+		}(), "подпись недействительна"},
+		{"без подписи", proc, result, nil, "не подписан"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, env, err := openResult(jobs, tt.from, job.ID, resultFrame(t, job.ID, tt.payload, tt.env))
+			if tt.wantErr == "" {
+				if err != nil || env == nil || string(payload) != string(result) {
+					t.Fatalf("результат не принят: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnvelopeDomains(t *testing.T) {
+	// Подпись записи сервиса тем же ключом не принимается как подпись результата
+	key, id := newPeerKey(t)
+	payload, _ := json.Marshal(Attestation{JobID: "job", Processor: id.String()})
+	env, err := signEnvelope(key, serviceDomain, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Open(id); err == nil {
+		t.Fatal("подпись другого назначения принята")
+	}
+}
@@ -0,0 +1,345 @@
+package p2p
+
+import (
+	"context"
+	"coursework_mimapr/internal/sched"
+	"coursework_mimapr/internal/style"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ServiceStyleTransfer — сервис, под которым процессоры объявляют себя в DHT
+const ServiceStyleTransfer = "style-transfer"
+
+// dhtPrefix отделяет DHT узлов курсовой от публичной сети IPFS
+const dhtPrefix = "/coursework"
+
+// serviceDomain отделяет подпись записи сервиса от других подписей ключом узла
+const serviceDomain = "coursework-service-record:"
+
+// ServiceRefresh — как часто процессор обновляет свою запись в DHT
+const ServiceRefresh = 30 * time.Second
+
+// serviceRetry — пауза перед повтором неудачного обновления записи
+const serviceRetry = 5 * time.Second
+
+// serviceRecordTTL — запись, не обновлявшаяся столько времени, считается записью ушедшего
+// процессора: он пропустил три обновления подряд
+const serviceRecordTTL = 3 * ServiceRefresh
+
+// discoveryTimeout — сколько инициатор ищет процессоры в DHT за один раз
+const discoveryTimeout = 15 * time.Second
+
+// ServiceRecord — запись процессора в DHT: адреса, возможности и загрузка
+type ServiceRecord struct {
+	Peer      string            `json:"peer"`
+	Addrs     []string          `json:"addrs"`
+	Load      map[string]string `json:"load"`                 // sched.Load в формате heartbeat
+	MaxEpochs int               `json:"max_epochs,omitempty"` // границы параметров заданий (style.Limits)
+	MaxSize   int               `json:"max_size,omitempty"`
+	Updated   time.Time         `json:"updated"`
+}
+
+// serviceKey — ключ записи процессора id в DHT
+func serviceKey(id peerstore.ID) string {
+	return "/" + ServiceStyleTransfer + "/" + id.String()
+}
+
+// serviceValidator принимает только записи, подписанные узлом из ключа записи,
+// и из нескольких записей одного узла выбирает самую свежую
+type serviceValidator struct{}
+
+func (serviceValidator) Validate(key string, value []byte) error {
+	_, err := openServiceRecord(key, value)
+	return err
+}
+
+func (serviceValidator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var newest time.Time
+	for i, value := range values {
+		r, err := openServiceRecord(key, value)
+		if err != nil {
+			continue
+		}
+		if best < 0 || r.Updated.After(newest) {
+			best, newest = i, r.Updated
+		}
+	}
+	if best < 0 {
+		return 0, errors.New("нет действительных записей сервиса")
+	}
+	return best, nil
+}
+
+// openServiceRecord проверяет подпись записи сервиса с ключом key и разбирает её
+func openServiceRecord(key string, value []byte) (ServiceRecord, error) {
+	rawID, ok := strings.CutPrefix(key, "/"+ServiceStyleTransfer+"/")
+	if !ok {
+		return ServiceRecord{}, fmt.Errorf("неверный ключ записи сервиса %q", key)
+	}
+	id, err := peerstore.Decode(rawID)
+	if err != nil {
+		return ServiceRecord{}, fmt.Errorf("неверный ID в ключе записи сервиса: %w", err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return ServiceRecord{}, fmt.Errorf("неверная запись сервиса: %w", err)
+	}
+	if err := env.verify(serviceDomain, id); err != nil {
+		return ServiceRecord{}, fmt.Errorf("запись сервиса: %w", err)
+	}
+	var r ServiceRecord
+	if err := json.Unmarshal(env.Payload, &r); err != nil {
+		return ServiceRecord{}, fmt.Errorf("неверная запись сервиса: %w", err)
+	}
+	if r.Peer != id.String() {
+		return ServiceRecord{}, fmt.Errorf("запись сервиса выдана для узла %s", r.Peer)
+	}
+	return r, nil
+}
+
+// StartDHT подключает узел к DHT узлов курсовой. peers — уже работающие узлы или сервер,
+// через которые узел входит в сеть; без них узел ждёт, пока к нему подключатся другие.
+func StartDHT(ctx context.Context, h host.Host, peers []peerstore.AddrInfo) (*dht.IpfsDHT, error) {
+	kad, err := dht.New(ctx, h,
+		dht.Mode(dht.ModeServer),
+		dht.ProtocolPrefix(dhtPrefix),
+		dht.NamespacedValidator(ServiceStyleTransfer, serviceValidator{}),
+		dht.BootstrapPeers(peers...),
+	)
+	if err != nil {
+		return nil, err
+	}
+	connected := 0
+	for _, info := range peers {
+		if err := h.Connect(ctx, info); err != nil {
+			log.Printf("⚠️ Узел DHT %s недоступен: %v\n", info.ID, err)
+			continue
+		}
+		connected++
+	}
+	if err := kad.Bootstrap(ctx); err != nil {
+		kad.Close()
+		return nil, err
+	}
+	fmt.Printf("🌐 Узел в DHT, подключено известных узлов: %d из %d\n", connected, len(peers))
+	return kad, nil
+}
+
+// AdvertiseService объявляет процессор в DHT под ServiceStyleTransfer и каждые ServiceRefresh
+// обновляет его запись с текущей загрузкой очереди, пока не отменён ctx
+func AdvertiseService(ctx context.Context, h host.Host, kad *dht.IpfsDHT, queue *JobQueue, gpu bool, limits style.Limits) {
+	dutil.Advertise(ctx, drouting.NewRoutingDiscovery(kad), ServiceStyleTransfer)
+	go func() {
+		for {
+			// Сразу после входа в DHT таблица маршрутизации может быть ещё пуста, повторяем раньше
+			next := ServiceRefresh
+			if err := putServiceRecord(ctx, h, kad, currentLoad(queue, gpu), limits); err != nil {
+				log.Println("⚠️ Ошибка обновления записи в DHT:", err)
+				next = serviceRetry
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next):
+			}
+		}
+	}()
+}
+
+// putServiceRecord подписывает запись процессора его ключом и сохраняет её в DHT
+func putServiceRecord(ctx context.Context, h host.Host, kad *dht.IpfsDHT, load sched.Load, limits style.Limits) error {
+	addrs := make([]string, 0, len(h.Addrs()))
+	for _, addr := range h.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+	payload, err := json.Marshal(ServiceRecord{
+		Peer:      h.ID().String(),
+		Addrs:     addrs,
+		Load:      load.Encode(),
+		MaxEpochs: limits.MaxEpochs,
+		MaxSize:   limits.MaxSize,
+		Updated:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	env, err := signEnvelope(h.Peerstore().PrivKey(h.ID()), serviceDomain, payload)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ServiceRefresh)
+	defer cancel()
+	return kad.PutValue(ctx, serviceKey(h.ID()), value)
+}
+
+// Discovery назначает процессоры заданиям без сервера: находит их записи в DHT и выбирает
+// одного той же стратегией sched, что и сервер
+type Discovery struct {
+	host     host.Host
+	dht      *dht.IpfsDHT
+	finder   *drouting.RoutingDiscovery
+	strategy sched.Strategy
+
+	refreshing sync.Mutex // поиск в DHT выполняется одним вызовом за раз, см. refresh
+
+	mu      sync.Mutex
+	records map[peerstore.ID]ServiceRecord
+	loads   map[peerstore.ID]*sched.Load
+	updated time.Time
+	groups  map[string][]peerstore.ID // процессоры, получившие копии задания группы
+}
+
+func NewDiscovery(h host.Host, kad *dht.IpfsDHT, strategy sched.Strategy) *Discovery {
+	return &Discovery{
+		host:     h,
+		dht:      kad,
+		finder:   drouting.NewRoutingDiscovery(kad),
+		strategy: strategy,
+		records:  make(map[peerstore.ID]ServiceRecord),
+		loads:    make(map[peerstore.ID]*sched.Load),
+		groups:   make(map[string][]peerstore.ID),
+	}
+}
+
+// RequestPeer выбирает процессор для задания req, как это сделал бы сервер: кроме exclude
+// и процессоров, получивших другие копии группы req.Group, и только среди тех, чьи границы
+// допускают параметры задания. Записи процессоров перечитываются из DHT не чаще раза в HeartbeatInterval;
+// пока идёт поиск, процессор выбирается по прежним записям. Записи старше serviceRecordTTL не учитываются.
+func (d *Discovery) RequestPeer(req ImageRequest, exclude []peerstore.ID) (peerstore.AddrInfo, error) {
+	d.mu.Lock()
+	stale, empty := time.Since(d.updated) > HeartbeatInterval, len(d.records) == 0
+	d.mu.Unlock()
+	switch {
+	case stale && empty:
+		d.refresh(true) // выбирать пока не из чего: ждём поиска
+	case stale:
+		go d.refresh(false)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	params := req.Params.Or(style.DefaultParams)
+	ids := make([]peerstore.ID, 0, len(d.records))
+	for id := range d.records {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids) // порядок важен стратегии round-robin
+	candidates := make([]sched.Candidate, 0, len(ids))
+	for _, id := range ids {
+		r := d.records[id]
+		if slices.Contains(exclude, id) || slices.Contains(d.groups[req.Group], id) {
+			continue
+		}
+		if time.Since(r.Updated) > serviceRecordTTL {
+			continue
+		}
+		if err := (style.Limits{MaxEpochs: r.MaxEpochs, MaxSize: r.MaxSize}).Check(params); err != nil {
+			continue
+		}
+		candidates = append(candidates, sched.Candidate{ID: id, Load: d.loads[id]})
+	}
+	id, ok := sched.Assign(d.strategy, candidates)
+	if !ok {
+		return peerstore.AddrInfo{}, ErrNoPeer
+	}
+	if req.Group != "" {
+		d.groups[req.Group] = append(d.groups[req.Group], id)
+	}
+	if load := d.loads[id]; load != nil {
+		fmt.Printf("📈 %s: очередь %d/%d, в работе %d/%d, ожидание ~%s\n",
+			id, load.Queued, load.Depth, load.Active, load.Workers, load.Wait())
+	}
+	return d.addrInfo(id), nil
+}
+
+// refresh перечитывает из DHT записи процессоров, объявивших ServiceStyleTransfer. Поиск идёт
+// без mu, чтобы назначения не ждали сети; одновременно выполняется один поиск: wait — дождаться
+// идущего поиска, иначе вернуться сразу. Записи старше serviceRecordTTL отбрасываются.
+func (d *Discovery) refresh(wait bool) {
+	if wait {
+		d.refreshing.Lock()
+	} else if !d.refreshing.TryLock() {
+		return
+	}
+	defer d.refreshing.Unlock()
+	d.mu.Lock()
+	fresh := time.Since(d.updated) <= HeartbeatInterval
+	d.mu.Unlock()
+	if fresh {
+		return // записи обновил поиск, который мы ждали
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	found, err := d.finder.FindPeers(ctx, ServiceStyleTransfer)
+	if err != nil {
+		log.Println("⚠️ Ошибка поиска процессоров в DHT:", err)
+		return
+	}
+	records := make(map[peerstore.ID]ServiceRecord)
+	loads := make(map[peerstore.ID]*sched.Load)
+	for info := range found {
+		if info.ID == d.host.ID() || info.ID == "" {
+			continue
+		}
+		if _, seen := records[info.ID]; seen {
+			continue
+		}
+		key := serviceKey(info.ID)
+		value, err := d.dht.GetValue(ctx, key, dht.Quorum(1))
+		if err != nil {
+			log.Printf("⚠️ Нет записи процессора %s в DHT: %v\n", info.ID, err)
+			continue
+		}
+		r, err := openServiceRecord(key, value)
+		if err != nil {
+			log.Printf("⚠️ Запись процессора %s отклонена: %v\n", info.ID, err)
+			continue
+		}
+		if time.Since(r.Updated) > serviceRecordTTL {
+			continue // процессор давно не обновлял запись: скорее всего, ушёл из сети
+		}
+		load := sched.DecodeLoad(r.Load)
+		load.Updated = r.Updated
+		records[info.ID], loads[info.ID] = r, &load
+		d.host.Peerstore().AddAddrs(info.ID, info.Addrs, time.Hour)
+	}
+	d.mu.Lock()
+	d.records, d.loads, d.updated = records, loads, time.Now()
+	d.mu.Unlock()
+	fmt.Println("🌐 Процессоров в DHT:", len(records))
+}
+
+// addrInfo собирает адреса процессора из его записи. Вызывается под mu.
+func (d *Discovery) addrInfo(id peerstore.ID) peerstore.AddrInfo {
+	info := peerstore.AddrInfo{ID: id}
+	for _, s := range d.records[id].Addrs {
+		if addr, err := ma.NewMultiaddr(s); err == nil {
+			info.Addrs = append(info.Addrs, addr)
+		}
+	}
+	if len(info.Addrs) == 0 {
+		info.Addrs = d.host.Peerstore().Addrs(id)
+	}
+	return info
+}
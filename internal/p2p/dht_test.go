@@ -0,0 +1,37 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/sched"
+	"errors"
+	"testing"
+	"time"
+
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestDiscoverySkipsStaleRecords(t *testing.T) {
+	record := func(id peerstore.ID, age time.Duration) ServiceRecord {
+		return ServiceRecord{Peer: id.String(), Addrs: []string{"/ip4/127.0.0.1/tcp/4001"}, Updated: time.Now().Add(-age)}
+	}
+	fresh, stale := peerstore.ID("fresh"), peerstore.ID("stale")
+	// Записи только что прочитаны: RequestPeer не идёт в DHT
+	d := &Discovery{
+		strategy: &sched.RoundRobin{},
+		records: map[peerstore.ID]ServiceRecord{
+			fresh: record(fresh, ServiceRefresh),
+			stale: record(stale, serviceRecordTTL+time.Second),
+		},
+		loads:   make(map[peerstore.ID]*sched.Load),
+		groups:  make(map[string][]peerstore.ID),
+		updated: time.Now(),
+	}
+	for i := 0; i < 3; i++ {
+		info, err := d.RequestPeer(ImageRequest{JobID: NewJobID()}, nil)
+		if err != nil || info.ID != fresh || len(info.Addrs) != 1 {
+			t.Fatalf("назначен %v (%v), ожидался %s", info, err, fresh)
+		}
+	}
+	if _, err := d.RequestPeer(ImageRequest{JobID: NewJobID()}, []peerstore.ID{fresh}); !errors.Is(err, ErrNoPeer) {
+		t.Fatalf("назначен процессор с устаревшей записью: %v", err)
+	}
+}
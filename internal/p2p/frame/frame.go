@@ -0,0 +1,266 @@
+// Package frame реализует общий бинарный формат сообщений протоколов узла (/2.0.0).
+//
+// Формат кадра (все числа big-endian):
+//
+//	magic    [4]byte  "MIMP"
+//	version  uint8
+//	type     uint8
+//	count    uint16   число пар метаданных
+//	count × { klen uint16, key, vlen uint16, value }  всего не больше MaxMeta байт
+//	length   uint64   длина полезной нагрузки
+//	payload  [length]byte
+//	checksum uint32   CRC-32 (IEEE) полезной нагрузки
+//
+// Длина нагрузки известна заранее, поэтому в одном потоке можно передавать
+// несколько кадров подряд, а обрыв передачи обнаруживается сразу.
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Magic открывает каждый кадр
+const Magic = "MIMP"
+
+// Version — текущая версия формата
+const Version uint8 = 1
+
+// MaxPayload ограничивает размер полезной нагрузки при чтении
+const MaxPayload = 256 << 20
+
+// MaxMeta ограничивает суммарный размер метаданных кадра: ключи, значения и их длины
+const MaxMeta = 64 << 10
+
+// Type — тип сообщения
+type Type uint8
+
+const (
+	TypeStyle        Type = 1  // файл признаков стиля
+	TypeImage        Type = 2  // изображение для стилизации
+	TypeResult       Type = 3  // стилизованное изображение
+	TypeError        Type = 4  // сообщение об ошибке, текст в нагрузке
+	TypeAck          Type = 5  // подтверждение приёма
+	TypeMissingStyle Type = 6  // у процессора нет стиля с указанным хэшем
+	TypeBusy         Type = 7  // очередь процессора заполнена, повторить позже
+	TypeHeartbeat    Type = 8  // загрузка процессора для планировщика
+	TypeRegister     Type = 9  // регистрация узла на сервере с указанием роли
+	TypePeerRequest  Type = 10 // запрос процессора у сервера для задания
+	TypePeer         Type = 11 // назначенный сервером процессор
+	TypeReport       Type = 12 // итог задания для расчёта токенов
+	TypeProgress     Type = 13 // задание ещё выполняется (keepalive в /stylize/1.0.0)
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeStyle:
+		return "STYLE"
+	case TypeImage:
+		return "IMAGE"
+	case TypeResult:
+		return "RESULT"
+	case TypeError:
+		return "ERROR"
+	case TypeAck:
+		return "ACK"
+	case TypeMissingStyle:
+		return "MISSING_STYLE"
+	case TypeBusy:
+		return "BUSY"
+	case TypeHeartbeat:
+		return "HEARTBEAT"
+	case TypeRegister:
+		return "REGISTER"
+	case TypePeerRequest:
+		return "PEER_REQUEST"
+	case TypePeer:
+		return "PEER"
+	case TypeReport:
+		return "REPORT"
+	case TypeProgress:
+		return "PROGRESS"
+	}
+	return fmt.Sprintf("TYPE(%d)", uint8(t))
+}
+
+var (
+	ErrBadMagic = errors.New("frame: неверная сигнатура")
+	ErrVersion  = errors.New("frame: неподдерживаемая версия")
+	ErrChecksum = errors.New("frame: контрольная сумма не совпадает")
+	ErrTooLarge = errors.New("frame: слишком большая нагрузка")
+	ErrMetaSize = errors.New("frame: слишком большие метаданные")
+)
+
+// Frame — одно сообщение протокола
+type Frame struct {
+	Version uint8
+	Type    Type
+	Meta    map[string]string
+	Payload []byte
+}
+
+// New создаёт кадр текущей версии
+func New(t Type, meta map[string]string, payload []byte) *Frame {
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	return &Frame{Version: Version, Type: t, Meta: meta, Payload: payload}
+}
+
+// Get возвращает значение метаданных или пустую строку
+func (f *Frame) Get(key string) string {
+	return f.Meta[key]
+}
+
+// Write сериализует кадр в w
+func Write(w io.Writer, f *Frame) error {
+	bw := bufio.NewWriter(w)
+
+	version := f.Version
+	if version == 0 {
+		version = Version
+	}
+	bw.WriteString(Magic)
+	bw.WriteByte(version)
+	bw.WriteByte(byte(f.Type))
+
+	if len(f.Meta) > 0xFFFF {
+		return fmt.Errorf("frame: слишком много метаданных (%d)", len(f.Meta))
+	}
+	// Ключи пишем по порядку, чтобы одинаковые кадры давали одинаковые байты
+	keys := make([]string, 0, len(f.Meta))
+	for k := range f.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf [8]byte
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(keys)))
+	bw.Write(buf[:2])
+	budget := MaxMeta
+	for _, k := range keys {
+		if err := writeString(bw, k, &budget); err != nil {
+			return err
+		}
+		if err := writeString(bw, f.Meta[k], &budget); err != nil {
+			return err
+		}
+	}
+
+	binary.BigEndian.PutUint64(buf[:8], uint64(len(f.Payload)))
+	bw.Write(buf[:8])
+	bw.Write(f.Payload)
+	binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(f.Payload))
+	bw.Write(buf[:4])
+	return bw.Flush()
+}
+
+// Read читает один кадр из r и проверяет его целостность
+func Read(r io.Reader) (*Frame, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if string(head[:4]) != Magic {
+		return nil, ErrBadMagic
+	}
+	f := &Frame{Version: head[4], Type: Type(head[5]), Meta: make(map[string]string)}
+	if f.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, f.Version)
+	}
+
+	// Метаданные читаются в пределах MaxMeta, чтобы кадр не заставил выделить лишнюю память
+	count := binary.BigEndian.Uint16(head[6:8])
+	budget := MaxMeta
+	for i := 0; i < int(count); i++ {
+		k, err := readString(r, &budget)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readString(r, &budget)
+		if err != nil {
+			return nil, err
+		}
+		f.Meta[k] = v
+	}
+
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+		return nil, unexpected(err)
+	}
+	length := binary.BigEndian.Uint64(buf[:8])
+	if length > MaxPayload {
+		return nil, fmt.Errorf("%w: %d байт", ErrTooLarge, length)
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, unexpected(err)
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, unexpected(err)
+	}
+	if binary.BigEndian.Uint32(buf[:4]) != crc32.ChecksumIEEE(f.Payload) {
+		return nil, ErrChecksum
+	}
+	return f, nil
+}
+
+// Expect читает кадр и проверяет его тип. Кадр TypeError превращается в ошибку.
+func Expect(r io.Reader, t Type) (*Frame, error) {
+	f, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type == TypeError && t != TypeError {
+		return f, fmt.Errorf("удалённая ошибка: %s", f.Payload)
+	}
+	if f.Type != t {
+		return f, fmt.Errorf("frame: ожидался %s, получен %s", t, f.Type)
+	}
+	return f, nil
+}
+
+// writeString пишет строку метаданных, уменьшая budget на её размер в кадре
+func writeString(w *bufio.Writer, s string, budget *int) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("frame: слишком длинная строка метаданных (%d)", len(s))
+	}
+	if *budget -= 2 + len(s); *budget < 0 {
+		return fmt.Errorf("%w: больше %d байт", ErrMetaSize, MaxMeta)
+	}
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], uint16(len(s)))
+	w.Write(buf[:])
+	w.WriteString(s)
+	return nil
+}
+
+// readString читает строку метаданных, если она умещается в budget, и уменьшает его
+func readString(r io.Reader, budget *int) (string, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", unexpected(err)
+	}
+	n := int(binary.BigEndian.Uint16(buf[:]))
+	if *budget -= 2 + n; *budget < 0 {
+		return "", fmt.Errorf("%w: больше %d байт", ErrMetaSize, MaxMeta)
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", unexpected(err)
+	}
+	return string(s), nil
+}
+
+// unexpected превращает EOF посреди кадра в ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// encode сериализует кадр или завершает тест
+func encode(t *testing.T, f *Frame) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	meta := map[string]string{"job": "abc", "name": "cat.jpg", "empty": ""}
+	var buf bytes.Buffer
+	for _, f := range []*Frame{New(TypeImage, meta, []byte("pixels")), New(TypeAck, nil, nil)} {
+		if err := Write(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Два кадра подряд в одном потоке
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != TypeImage || got.Version != Version || string(got.Payload) != "pixels" {
+		t.Fatalf("прочитан кадр %s v%d %q", got.Type, got.Version, got.Payload)
+	}
+	for k, v := range meta {
+		if got.Get(k) != v {
+			t.Errorf("метаданные %s = %q, ожидалось %q", k, got.Get(k), v)
+		}
+	}
+	if got, err := Read(&buf); err != nil || got.Type != TypeAck || len(got.Payload) != 0 {
+		t.Fatalf("второй кадр: %v, %v", got, err)
+	}
+	if _, err := Read(&buf); err != io.EOF {
+		t.Fatalf("после последнего кадра ожидался io.EOF, получено %v", err)
+	}
+}
+
+func TestWriteIsDeterministic(t *testing.T) {
+	meta := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	first := encode(t, New(TypeHeartbeat, meta, nil))
+	for i := 0; i < 10; i++ {
+		if !bytes.Equal(encode(t, New(TypeHeartbeat, meta, nil)), first) {
+			t.Fatal("одинаковые кадры сериализованы по-разному")
+		}
+	}
+}
+
+func TestReadRejectsCorruptFrames(t *testing.T) {
+	valid := encode(t, New(TypeResult, map[string]string{"job": "abc"}, []byte("result")))
+	corrupt := func(fn func(b []byte)) []byte {
+		b := bytes.Clone(valid)
+		fn(b)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"сигнатура", corrupt(func(b []byte) { b[0] = 'X' }), ErrBadMagic},
+		{"версия", corrupt(func(b []byte) { b[4] = Version + 1 }), ErrVersion},
+		{"контрольная сумма", corrupt(func(b []byte) { b[len(b)-1] ^= 0xFF }), ErrChecksum},
+		{"нагрузка", corrupt(func(b []byte) { b[len(b)-5] ^= 0xFF }), ErrChecksum},
+		{"обрыв в метаданных", valid[:10], io.ErrUnexpectedEOF},
+		{"обрыв в нагрузке", valid[:len(valid)-6], io.ErrUnexpectedEOF},
+		{"обрыв в контрольной сумме", valid[:len(valid)-2], io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// header собирает начало кадра с count парами метаданных
+func header(count uint16) []byte {
+	b := append([]byte(Magic), Version, byte(TypeImage))
+	return binary.BigEndian.AppendUint16(b, count)
+}
+
+func TestReadLimitsPayload(t *testing.T) {
+	b := binary.BigEndian.AppendUint64(header(0), MaxPayload+1)
+	if _, err := Read(bytes.NewReader(b)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("ошибка %v, ожидалась %v", err, ErrTooLarge)
+	}
+}
+
+func TestReadLimitsMeta(t *testing.T) {
+	// Кадр объявляет 65535 пар по 64 КБ: читатель должен остановиться, не дочитав и не выделив их
+	b := header(0xFFFF)
+	value := strings.Repeat("v", 0xFFFF)
+	for i := 0; i < 4; i++ {
+		b = binary.BigEndian.AppendUint16(b, 1)
+		b = append(b, byte('a'+i))
+		b = binary.BigEndian.AppendUint16(b, 0xFFFF)
+		b = append(b, value...)
+	}
+	r := bytes.NewReader(b)
+	if _, err := Read(r); !errors.Is(err, ErrMetaSize) {
+		t.Fatalf("ошибка %v, ожидалась %v", err, ErrMetaSize)
+	}
+	if read := len(b) - r.Len(); read > MaxMeta+len(header(0))+4 {
+		t.Fatalf("прочитано %d байт метаданных сверх MaxMeta", read)
+	}
+}
+
+func TestWriteLimitsMeta(t *testing.T) {
+	meta := map[string]string{"a": strings.Repeat("v", MaxMeta/2), "b": strings.Repeat("v", MaxMeta/2)}
+	if err := Write(io.Discard, New(TypeImage, meta, nil)); !errors.Is(err, ErrMetaSize) {
+		t.Fatalf("ошибка %v, ожидалась %v", err, ErrMetaSize)
+	}
+	meta = map[string]string{"a": strings.Repeat("v", MaxMeta/2)}
+	if err := Write(io.Discard, New(TypeImage, meta, nil)); err != nil {
+		t.Fatalf("метаданные в пределах MaxMeta не записаны: %v", err)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	t.Run("нужный тип", func(t *testing.T) {
+		f, err := Expect(bytes.NewReader(encode(t, New(TypeAck, nil, nil))), TypeAck)
+		if err != nil || f.Type != TypeAck {
+			t.Fatalf("получено %v, %v", f, err)
+		}
+	})
+	t.Run("удалённая ошибка", func(t *testing.T) {
+		data := encode(t, New(TypeError, map[string]string{"job": "abc"}, []byte("нет стиля")))
+		f, err := Expect(bytes.NewReader(data), TypeAck)
+		if err == nil || !strings.Contains(err.Error(), "нет стиля") {
+			t.Fatalf("ошибка %v, ожидался текст удалённой ошибки", err)
+		}
+		// Кадр ошибки возвращается вместе с ошибкой: по нему видно, что сервер ответил
+		if f == nil || f.Get("job") != "abc" {
+			t.Fatalf("кадр ошибки не возвращён: %v", f)
+		}
+	})
+	t.Run("ожидается ошибка", func(t *testing.T) {
+		f, err := Expect(bytes.NewReader(encode(t, New(TypeError, nil, []byte("x")))), TypeError)
+		if err != nil || f.Type != TypeError {
+			t.Fatalf("получено %v, %v", f, err)
+		}
+	})
+	t.Run("другой тип", func(t *testing.T) {
+		f, err := Expect(bytes.NewReader(encode(t, New(TypeBusy, nil, nil))), TypeAck)
+		if err == nil || f == nil || f.Type != TypeBusy {
+			t.Fatalf("получено %v, %v; ожидалась ошибка типа с кадром", f, err)
+		}
+	})
+	t.Run("нет кадра", func(t *testing.T) {
+		f, err := Expect(bytes.NewReader(nil), TypeAck)
+		if err != io.EOF || f != nil {
+			t.Fatalf("получено %v, %v; ожидался io.EOF без кадра", f, err)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package p2p
+
+import (
+	"context"
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/sched"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// HeartbeatInterval — как часто процессор сообщает серверу свою загрузку
+const HeartbeatInterval = 10 * time.Second
+
+// StartHeartbeat периодически отправляет текущему серверу загрузку очереди процессора,
+// пока не отменён ctx. gpu — работает ли модель на видеокарте. Если сервер перестал
+// отвечать, процессор переходит на следующий сервер списка.
+func StartHeartbeat(ctx context.Context, h host.Host, servers *Servers, queue *JobQueue, gpu bool) {
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			err := servers.do(func(server peerstore.AddrInfo) error {
+				err := SendHeartbeat(ctx, h, server, queue, gpu)
+				if err != nil && ctx.Err() == nil {
+					return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+				}
+				return err
+			})
+			if err != nil {
+				log.Println("⚠️ Ошибка отправки heartbeat:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SendHeartbeat отправляет серверу одно сообщение о загрузке по протоколу "/heartbeat/1.0.0"
+func SendHeartbeat(ctx context.Context, h host.Host, server peerstore.AddrInfo, queue *JobQueue, gpu bool) error {
+	load := currentLoad(queue, gpu)
+	ctx, cancel := context.WithTimeout(ctx, HeartbeatInterval)
+	defer cancel()
+	stream, err := h.NewStream(ctx, server.ID, ProtoHeartbeat)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return frame.Write(stream, frame.New(frame.TypeHeartbeat, load.Encode(), nil))
+}
+
+// currentLoad возвращает загрузку очереди процессора и его возможности
+func currentLoad(queue *JobQueue, gpu bool) sched.Load {
+	stats := queue.Stats()
+	return sched.Load{
+		Queued:      stats.Queued,
+		Depth:       stats.Depth,
+		Active:      stats.Active,
+		Workers:     stats.Workers,
+		AvgDuration: stats.AvgDuration,
+		CPUs:        runtime.NumCPU(),
+		GPU:         gpu,
+	}
+}
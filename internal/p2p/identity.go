@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"os"
+
+	crypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// LoadOrCreateKey читает приватный ключ узла из path или создаёт новый ключ Ed25519
+// и сохраняет его туда. С постоянным ключом у узла постоянный ID между запусками.
+func LoadOrCreateKey(path string) (crypto.PrivKey, error) {
+	if _, err := os.Stat(path); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.UnmarshalPrivateKey(data)
+	}
+
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
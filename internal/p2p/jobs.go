@@ -0,0 +1,384 @@
+package p2p
+
+import (
+	"context"
+	"coursework_mimapr/internal/seal"
+	"coursework_mimapr/internal/style"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// JobState — состояние задания на стороне инициатора
+type JobState string
+
+const (
+	JobSent     JobState = "sent"      // изображение передаётся процессору
+	JobInFlight JobState = "in-flight" // изображение принято, ждём результат
+	JobRetrying JobState = "retrying"  // попытка не удалась, задание будет отправлено повторно
+	JobDone     JobState = "done"      // результат получен и сохранён
+	JobFailed   JobState = "failed"    // процессор или сеть сообщили об ошибке
+)
+
+// Terminal сообщает, что задание больше не изменится
+func (s JobState) Terminal() bool {
+	return s == JobDone || s == JobFailed
+}
+
+// Job — одно изображение, отправленное на стилизацию
+type Job struct {
+	ID         string
+	FileName   string // имя файла результата: исходное, а при совпадении с другим заданием в том же каталоге — с суффиксом -2, -3…
+	InputPath  string
+	StylePath  string       // изображение-стиль, которым стилизуется задание
+	OutputDir  string       // куда сохранить результат; пусто — каталог обработчика результатов
+	Parent     string       // задание целого изображения, если это его фрагмент
+	Peer       peerstore.ID // процессор текущей попытки
+	Server     peerstore.ID // сервер, зарезервировавший токены текущей попытки; пусто — процессор найден в DHT
+	Attempts   int          // сколько раз задание назначалось процессору
+	State      JobState
+	ResultPath string
+	Sizes      style.Result // рабочий и итоговый размеры результата, если процессор их сообщил
+	Error      string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// JobTable хранит задания инициатора и их состояния
+type JobTable struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	order    []string
+	names    map[string]bool // занятые имена результатов: путь в каталоге результатов, см. uniqueName
+	changed  chan struct{}   // закрывается и заменяется при каждом изменении, см. Wait
+	onChange func(Job)
+	keys     map[string]*seal.Keys  // ключи зашифрованных заданий; только в памяти, не попадают в копии Job
+	sent     map[string]Attestation // что отправлено процессору: с этим сверяется подпись результата
+}
+
+func NewJobTable() *JobTable {
+	return &JobTable{jobs: make(map[string]*Job), names: make(map[string]bool), changed: make(chan struct{}), keys: make(map[string]*seal.Keys), sent: make(map[string]Attestation)}
+}
+
+// NewJobID генерирует случайный идентификатор задания
+func NewJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// jobIDPattern — допустимый идентификатор задания от другого узла: он попадает в имена файлов
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// CheckJobID проверяет идентификатор задания, полученный от другого узла
+func CheckJobID(id string) error {
+	if !jobIDPattern.MatchString(id) {
+		return errors.New("неверный идентификатор задания: допустимы латинские буквы, цифры, _ и -, не длиннее 64 символов")
+	}
+	return nil
+}
+
+// Add регистрирует новое задание для файла inputPath. Входы с одинаковым именем из разных
+// каталогов получают в outputDir разные имена результатов.
+func (t *JobTable) Add(inputPath, stylePath, outputDir string) *Job {
+	return t.add("", inputPath, stylePath, outputDir)
+}
+
+// AddPart регистрирует фрагмент inputPath изображения из задания parent.
+// Фрагмент отправляется процессору как отдельное задание.
+func (t *JobTable) AddPart(parent, inputPath, stylePath, outputDir string) *Job {
+	return t.add(parent, inputPath, stylePath, outputDir)
+}
+
+func (t *JobTable) add(parent, inputPath, stylePath, outputDir string) *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	job := &Job{
+		ID:        NewJobID(),
+		Parent:    parent,
+		FileName:  filepath.Base(inputPath),
+		InputPath: inputPath,
+		StylePath: stylePath,
+		OutputDir: outputDir,
+		State:     JobSent,
+		Created:   now,
+		Updated:   now,
+	}
+	if parent == "" {
+		// Фрагменты и копии сохраняются в собственные каталоги задания
+		job.FileName = t.uniqueName(outputDir, job.FileName)
+	}
+	t.jobs[job.ID] = job
+	t.order = append(t.order, job.ID)
+	return job
+}
+
+// uniqueName возвращает имя результата name в каталоге dir, не занятое другим заданием:
+// второе задание с тем же именем получает name-2, третье — name-3 и т. д. Вызывается под mu.
+func (t *JobTable) uniqueName(dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := name
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		if key := filepath.Join(dir, candidate); !t.names[key] {
+			t.names[key] = true
+			return candidate
+		}
+	}
+}
+
+// Keys возвращает ключи шифрования задания id, создавая их при первом обращении
+func (t *JobTable) Keys(id string) (*seal.Keys, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if keys, ok := t.keys[id]; ok {
+		return keys, nil
+	}
+	keys, err := seal.NewKeys()
+	if err != nil {
+		return nil, err
+	}
+	t.keys[id] = keys
+	return keys, nil
+}
+
+// keysOf возвращает ключи задания id или nil, если задание отправлялось без шифрования
+func (t *JobTable) keysOf(id string) *seal.Keys {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.keys[id]
+}
+
+// expect запоминает, что отправлено процессору по заданию id
+func (t *JobTable) expect(id string, a Attestation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent[id] = a
+}
+
+// expected возвращает то, что отправлено процессору по заданию id
+func (t *JobTable) expected(id string) (Attestation, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.sent[id]
+	return a, ok
+}
+
+// Get возвращает копию задания
+func (t *JobTable) Get(id string) (Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Assign запоминает процессор, которому отправлено задание, и сервер, зарезервировавший
+// за него токены, и начинает новую попытку
+func (t *JobTable) Assign(id string, p, server peerstore.ID) {
+	t.update(id, func(j *Job) {
+		j.Peer = p
+		j.Server = server
+		j.Attempts++
+		j.State = JobSent
+	})
+}
+
+// AttemptFailed отмечает, что текущая попытка не удалась: задание ждёт повторной отправки.
+// Сообщения от процессоров прошлых попыток (from не совпадает с текущим) игнорируются;
+// пустой from — ошибка на стороне инициатора.
+func (t *JobTable) AttemptFailed(id string, from peerstore.ID, reason string) bool {
+	t.mu.Lock()
+	job, ok := t.jobs[id]
+	stale := ok && from != "" && job.Peer != from
+	t.mu.Unlock()
+	if stale {
+		return false
+	}
+	return t.update(id, func(j *Job) {
+		j.State = JobRetrying
+		j.Error = reason
+	})
+}
+
+// InFlight отмечает, что изображение передано и ожидается результат.
+// Если процессор уже успел сообщить об ошибке, состояние не меняется.
+func (t *JobTable) InFlight(id string) {
+	t.update(id, func(j *Job) {
+		if j.State == JobSent {
+			j.State = JobInFlight
+		}
+	})
+}
+
+// Done отмечает успешное завершение задания
+func (t *JobTable) Done(id, resultPath string, sizes style.Result) {
+	t.update(id, func(j *Job) {
+		j.State = JobDone
+		j.ResultPath = resultPath
+		j.Sizes = sizes
+	})
+}
+
+// Fail отмечает задание как неудачное. false — задание уже завершено.
+func (t *JobTable) Fail(id, reason string) bool {
+	return t.update(id, func(j *Job) {
+		j.State = JobFailed
+		j.Error = reason
+	})
+}
+
+// update применяет изменение, если задание ещё не завершено.
+// Результат может прийти раньше, чем отправитель отметит задание как in-flight.
+func (t *JobTable) update(id string, fn func(*Job)) bool {
+	t.mu.Lock()
+	job, ok := t.jobs[id]
+	if !ok || job.State.Terminal() {
+		t.mu.Unlock()
+		return false
+	}
+	fn(job)
+	job.Updated = time.Now()
+	close(t.changed)
+	t.changed = make(chan struct{})
+	snapshot, onChange := *job, t.onChange
+	t.mu.Unlock()
+
+	if onChange != nil {
+		onChange(snapshot)
+	}
+	return true
+}
+
+// OnChange задаёт функцию, которая получает копию задания после каждого изменения.
+// Вызывается вне блокировки таблицы, возможно из разных горутин.
+func (t *JobTable) OnChange(fn func(Job)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onChange = fn
+}
+
+// Wait ждёт, пока все задания завершатся (done или failed), или отмены ctx
+func (t *JobTable) Wait(ctx context.Context) error {
+	return t.waitFor(ctx, func() bool {
+		for _, job := range t.jobs {
+			if !job.State.Terminal() {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WaitJob ждёт, пока задание id выйдет из состояния state, и возвращает его копию
+func (t *JobTable) WaitJob(ctx context.Context, id string, state JobState) (Job, error) {
+	var job Job
+	err := t.waitFor(ctx, func() bool {
+		j, ok := t.jobs[id]
+		if ok {
+			job = *j
+		}
+		return !ok || j.State != state
+	})
+	return job, err
+}
+
+// WaitAll ждёт, пока все задания ids завершатся, и возвращает их копии
+func (t *JobTable) WaitAll(ctx context.Context, ids []string) ([]Job, error) {
+	jobs := make([]Job, len(ids))
+	err := t.waitFor(ctx, func() bool {
+		for i, id := range ids {
+			j, ok := t.jobs[id]
+			if ok {
+				jobs[i] = *j
+			}
+			if ok && !j.State.Terminal() {
+				return false
+			}
+		}
+		return true
+	})
+	return jobs, err
+}
+
+// waitFor ждёт, пока cond (вызывается под t.mu) не вернёт true, или отмены ctx
+func (t *JobTable) waitFor(ctx context.Context, cond func() bool) error {
+	for {
+		t.mu.Lock()
+		ok := cond()
+		changed := t.changed
+		t.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Expire отмечает все незавершённые задания неудачными и возвращает их
+func (t *JobTable) Expire(reason string) []Job {
+	var expired []Job
+	for _, job := range t.List() {
+		if job.State.Terminal() {
+			continue
+		}
+		if t.Fail(job.ID, reason) {
+			job, _ := t.Get(job.ID)
+			expired = append(expired, job)
+		}
+	}
+	return expired
+}
+
+// List возвращает задания в порядке добавления
+func (t *JobTable) List() []Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]Job, 0, len(t.order))
+	for _, id := range t.order {
+		list = append(list, *t.jobs[id])
+	}
+	return list
+}
+
+// Counts возвращает число заданий в каждом состоянии
+func (t *JobTable) Counts() map[JobState]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[JobState]int)
+	for _, job := range t.jobs {
+		counts[job.State]++
+	}
+	return counts
+}
+
+// Summary — короткая строка со статистикой для логов
+func (t *JobTable) Summary() string {
+	counts := t.Counts()
+	states := []JobState{JobSent, JobInFlight, JobRetrying, JobDone, JobFailed}
+	parts := make([]string, 0, len(states))
+	for _, s := range states {
+		parts = append(parts, fmt.Sprintf("%s: %d", s, counts[s]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,29 @@
+package p2p
+
+import "testing"
+
+func TestAddUniqueResultNames(t *testing.T) {
+	jobs := NewJobTable()
+	tests := []struct {
+		input, output, want string
+	}{
+		{"a/cat.jpg", "out", "cat.jpg"},
+		{"b/cat.jpg", "out", "cat-2.jpg"},
+		{"c/cat.jpg", "out", "cat-3.jpg"},
+		{"a/cat.jpg", "other", "cat.jpg"},
+		{"d/cat-2.jpg", "out", "cat-2-2.jpg"},
+		{"a/dog", "out", "dog"},
+		{"b/dog", "out", "dog-2"},
+	}
+	for _, tt := range tests {
+		if got := jobs.Add(tt.input, "style.jpg", tt.output).FileName; got != tt.want {
+			t.Errorf("%s в %s: имя результата %q, ожидалось %q", tt.input, tt.output, got, tt.want)
+		}
+	}
+
+	// Фрагменты и копии сохраняются в свои каталоги и имён не занимают
+	parent := jobs.Add("e/fox.jpg", "style.jpg", "out")
+	if part := jobs.AddPart(parent.ID, "e/fox.jpg", "style.jpg", "out"); part.FileName != "fox.jpg" {
+		t.Errorf("имя результата фрагмента %q", part.FileName)
+	}
+}
@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalEntry — одна строка журнала: состояние задания на момент записи
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	Job       string    `json:"job"`
+	Input     string    `json:"input"`
+	InputHash string    `json:"input_hash"` // SHA-256 исходного изображения
+	Style     string    `json:"style"`
+	StyleHash string    `json:"style_hash"`       // SHA-256 изображения-стиля
+	Params    string    `json:"params,omitempty"` // параметры стилизации (style.Params.String)
+	Output    string    `json:"output,omitempty"` // каталог результата
+	State     JobState  `json:"state"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Journal — журнал заданий инициатора в формате JSON Lines. Строки только добавляются,
+// при чтении для каждого сочетания изображения, стиля, параметров и каталога результата
+// действует последняя запись.
+// По журналу повторный запуск пропускает уже выполненные изображения.
+type Journal struct {
+	mu     sync.Mutex
+	file   *os.File
+	last   map[string]JournalEntry // ключ journalKey → последняя запись
+	tracks map[string]JournalEntry // jobID → задание текущего запуска
+}
+
+// OpenJournal читает журнал из path (если он есть) и открывает его для дописывания
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{last: make(map[string]JournalEntry), tracks: make(map[string]JournalEntry)}
+	if err := j.load(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Недописанную при аварии строку завершаем, чтобы новая запись не склеилась с ней
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			file.Write([]byte{'\n'})
+		}
+	}
+	j.file = file
+	return j, nil
+}
+
+func (j *Journal) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Последняя строка могла не дописаться при аварийном завершении
+			log.Printf("⚠️ Журнал %s, строка %d пропущена: %v\n", path, line, err)
+			continue
+		}
+		j.last[journalKey(e.InputHash, e.StyleHash, e.Params, e.Output)] = e
+	}
+	return scanner.Err()
+}
+
+func journalKey(inputHash, styleHash, params, output string) string {
+	return inputHash + "/" + styleHash + "/" + params + "/" + filepath.Clean(output)
+}
+
+// Completed возвращает запись о выполненном задании для изображения, стиля и параметров
+// с результатом в каталоге output, если результат всё ещё лежит на диске
+func (j *Journal) Completed(inputHash, styleHash, params, output string) (JournalEntry, bool) {
+	if j == nil {
+		return JournalEntry{}, false
+	}
+	j.mu.Lock()
+	e, ok := j.last[journalKey(inputHash, styleHash, params, output)]
+	j.mu.Unlock()
+	if !ok || e.State != JobDone || e.Result == "" {
+		return JournalEntry{}, false
+	}
+	if _, err := os.Stat(e.Result); err != nil {
+		return JournalEntry{}, false
+	}
+	return e, true
+}
+
+// Track начинает записывать изменения задания job текущего запуска
+func (j *Journal) Track(job Job, inputHash, styleHash, params string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.tracks[job.ID] = JournalEntry{
+		Job:       job.ID,
+		Input:     job.InputPath,
+		InputHash: inputHash,
+		Style:     job.StylePath,
+		StyleHash: styleHash,
+		Params:    params,
+		Output:    job.OutputDir,
+	}
+	j.mu.Unlock()
+	j.Record(job)
+}
+
+// Record дописывает в журнал новое состояние задания. Подходит для JobTable.OnChange.
+func (j *Journal) Record(job Job) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.tracks[job.ID]
+	if !ok || e.State == job.State {
+		return
+	}
+	e.Time = time.Now()
+	e.State = job.State
+	e.Result = job.ResultPath
+	e.Error = job.Error
+	j.tracks[job.ID] = e
+	j.last[journalKey(e.InputHash, e.StyleHash, e.Params, e.Output)] = e
+
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = j.file.Write(append(line, '\n'))
+	}
+	if err == nil && e.State.Terminal() {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		log.Println("⚠️ Ошибка записи журнала:", err)
+	}
+}
+
+// Close закрывает файл журнала
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/style"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openJournal открывает журнал или завершает тест
+func openJournal(t *testing.T, path string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// journalJob регистрирует задание для input в out и проводит его через журнал до состояния state;
+// результат выполненного задания записывается на диск
+func journalJob(t *testing.T, j *Journal, input, out string, state JobState) Job {
+	t.Helper()
+	jobs := NewJobTable()
+	jobs.OnChange(j.Record)
+	job := jobs.Add(input, "style.jpg", out)
+	j.Track(*job, "in-"+input, "style", "epochs=1")
+	jobs.InFlight(job.ID)
+	switch state {
+	case JobDone:
+		result := filepath.Join(out, job.FileName)
+		if err := os.MkdirAll(out, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(result, []byte("result"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		jobs.Done(job.ID, result, style.Result{})
+	case JobFailed:
+		jobs.Fail(job.ID, "ошибка")
+	}
+	done, _ := jobs.Get(job.ID)
+	return done
+}
+
+func TestJournalAppendAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+	out := filepath.Join(dir, "out")
+
+	j := openJournal(t, path)
+	done := journalJob(t, j, "cat.jpg", out, JobDone)
+	j.Close()
+
+	// Каждое изменение состояния — отдельная строка: sent, in-flight, done
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Fatalf("в журнале %d строк, ожидалось 3:\n%s", lines, data)
+	}
+
+	j = openJournal(t, path)
+	e, ok := j.Completed("in-cat.jpg", "style", "epochs=1", out)
+	if !ok || e.Result != done.ResultPath || e.Job != done.ID {
+		t.Fatalf("выполненное задание не найдено после повторного открытия: %+v, %v", e, ok)
+	}
+}
+
+func TestJournalTruncatedLastLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+	out := filepath.Join(dir, "out")
+
+	j := openJournal(t, path)
+	journalJob(t, j, "cat.jpg", out, JobDone)
+	j.Close()
+
+	// Аварийное завершение посреди записи оставляет строку без конца
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-01T00:00:00Z","job":"x","input_ha`)
+	f.Close()
+
+	j = openJournal(t, path)
+	if _, ok := j.Completed("in-cat.jpg", "style", "epochs=1", out); !ok {
+		t.Fatal("записи до недописанной строки потеряны")
+	}
+	// Новая запись не склеивается с недописанной строкой
+	journalJob(t, j, "dog.jpg", out, JobDone)
+	j.Close()
+
+	j = openJournal(t, path)
+	for _, input := range []string{"cat.jpg", "dog.jpg"} {
+		if _, ok := j.Completed("in-"+input, "style", "epochs=1", out); !ok {
+			t.Errorf("%s не найден после дописывания за недописанной строкой", input)
+		}
+	}
+}
+
+func TestJournalCompleted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+	out := filepath.Join(dir, "out")
+
+	j := openJournal(t, path)
+	journalJob(t, j, "done.jpg", out, JobDone)
+	journalJob(t, j, "pending.jpg", out, JobInFlight)
+	journalJob(t, j, "failed.jpg", out, JobFailed)
+	removed := journalJob(t, j, "removed.jpg", out, JobDone)
+	os.Remove(removed.ResultPath)
+	j.Close()
+
+	j = openJournal(t, path)
+	tests := []struct {
+		name, input, out string
+		want             bool
+	}{
+		{"выполнено", "done.jpg", out, true},
+		{"другой каталог результата", "done.jpg", filepath.Join(dir, "other"), false},
+		{"не завершено", "pending.jpg", out, false},
+		{"ошибка", "failed.jpg", out, false},
+		{"результат удалён", "removed.jpg", out, false},
+		{"нет в журнале", "new.jpg", out, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := j.Completed("in-"+tt.input, "style", "epochs=1", tt.out); ok != tt.want {
+				t.Fatalf("Completed = %v, ожидалось %v", ok, tt.want)
+			}
+		})
+	}
+	if _, ok := j.Completed("in-done.jpg", "style", "epochs=2", out); ok {
+		t.Fatal("задание с другими параметрами считается выполненным")
+	}
+}
@@ -0,0 +1,199 @@
+package p2p
+
+import (
+	"bufio"
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/style"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	network "github.com/libp2p/go-libp2p/core/network"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ================= Совместимость с протоколами /1.0.0 =================
+// Текстовый заголовок в первой строке, затем данные до конца потока.
+
+// Обработчик "/receive-image-result/1.0.0" для процессоров старой версии.
+// Протокол не передаёт подпись процессора, поэтому результаты принимаются, только если
+// allowUnsigned (флаг --allow-unsigned), и только для заданий без шифрования.
+func MakeReceiveResultHandlerV1(jobs *JobTable, outDir string, allowUnsigned bool) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		reader := bufio.NewReader(s)
+
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			log.Println("❌ Ошибка чтения результата:", err)
+			return
+		}
+		kind, jobID, fileName := parseJobHeader(header)
+
+		switch kind {
+		case "ERROR":
+			msg, _ := reader.ReadString('\n')
+			failResult(jobs, s.Conn().RemotePeer(), jobID, strings.TrimSpace(msg))
+		case "IMAGE":
+			from := s.Conn().RemotePeer()
+			switch {
+			case !allowUnsigned:
+				failResult(jobs, from, jobID, "результат без подписи процессора (протокол 1.0.0) не принимается, см. --allow-unsigned")
+			case jobs.keysOf(jobID) != nil:
+				failResult(jobs, from, jobID, "процессор вернул незашифрованный результат")
+			default:
+				log.Printf("⚠️ Результат задания %s от %s не подписан процессором (протокол 1.0.0)\n", jobID, from)
+				saveResult(jobs, outDir, from, jobID, fileName, style.Result{}, nil, reader)
+			}
+		default:
+			log.Println("❌ Неизвестный заголовок результата:", strings.TrimSpace(header))
+		}
+	}
+}
+
+// legacyStyles — хэш последнего стиля, полученного по "/receive-style/1.0.0", по инициаторам.
+// Старый протокол не передаёт хэш в запросе изображения, поэтому для таких
+// инициаторов используется последний присланный ими стиль.
+var (
+	legacyMu     sync.Mutex
+	legacyStyles = make(map[peerstore.ID]string)
+)
+
+// Обработчик "/receive-style/1.0.0" для инициаторов старой версии
+func MakeReceiveStyleHandlerV1(styles *style.Store) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		reader := bufio.NewReader(s)
+
+		// Читаем заголовок
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			log.Println("❌ Ошибка чтения заголовка стиля:", err)
+			return
+		}
+		header = strings.TrimSpace(header)
+		if header != "STYLE" {
+			log.Println("❌ Ожидался заголовок 'STYLE', получено:", header)
+			return
+		}
+
+		// Стиль ограничен тем же размером, что и нагрузка кадра протокола 2.0.0
+		data, err := io.ReadAll(io.LimitReader(reader, frame.MaxPayload+1))
+		if err != nil {
+			log.Println("❌ Ошибка чтения файла стиля:", err)
+			return
+		}
+		if len(data) > frame.MaxPayload {
+			log.Printf("❌ Файл стиля от %s больше %d байт, отклонён\n", s.Conn().RemotePeer(), frame.MaxPayload)
+			return
+		}
+		hash, err := styles.Put(data)
+		if err != nil {
+			log.Println("❌ Ошибка сохранения файла стиля:", err)
+			return
+		}
+		legacyMu.Lock()
+		legacyStyles[s.Conn().RemotePeer()] = hash
+		legacyMu.Unlock()
+		fmt.Println("🎨 Файл стиля получен и сохранен как:", styles.Path(hash))
+	}
+}
+
+// Обработчик "/receive-image/1.0.0" для инициаторов старой версии
+// Протокол не умеет отвечать "busy", поэтому при заполненной очереди инициатор получает ошибку задания.
+func MakeReceiveImageHandlerV1(p *Processor) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		reader := bufio.NewReader(s)
+
+		// Читаем заголовок (ожидается "IMAGE <jobID> <имя файла>")
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			log.Println("❌ Ошибка чтения заголовка:", err)
+			return
+		}
+		kind, jobID, fileName := parseJobHeader(header)
+		if kind != "IMAGE" || jobID == "" {
+			log.Println("❌ Неверный заголовок, ожидается IMAGE <jobID> <файл>, получено:", strings.TrimSpace(header))
+			return
+		}
+		if err := CheckJobID(jobID); err != nil {
+			log.Printf("❌ Задание %q отклонено: %v\n", jobID, err)
+			return
+		}
+		initiator := s.Conn().RemotePeer()
+		legacyMu.Lock()
+		styleHash := legacyStyles[initiator]
+		legacyMu.Unlock()
+		if !p.Styles.Has(styleHash) {
+			log.Printf("❌ Стиль от %s для протокола 1.0.0 ещё не получен, задание %s\n", initiator, jobID)
+			return
+		}
+
+		tmpIn, fileName, err := saveIncomingImage(initiator, jobID, fileName, imageExt(fileName), reader)
+		if err != nil {
+			log.Println("❌ Ошибка сохранения полученного изображения:", err)
+			return
+		}
+
+		addrs := []ma.Multiaddr{s.Conn().RemoteMultiaddr()}
+		accepted := p.Queue.Submit(func() {
+			// Протокол 1.0.0 не передаёт параметры, используются значения по умолчанию
+			task := imageTask{JobID: jobID, Initiator: initiator, FileName: fileName, TmpIn: tmpIn, StylePath: p.Styles.Path(styleHash), StyleHash: styleHash, Params: style.DefaultParams}
+			p.stylizeAndReply(initiator, addrs, task)
+		})
+		if !accepted {
+			os.Remove(tmpIn)
+			log.Println("🚦 Очередь заполнена, задание отклонено:", jobID)
+			SendProcessedImage(p.Host, initiator, addrs, jobID, fileName, ResultFile{}, "процессор занят, повторите позже")
+		}
+	}
+}
+
+// parseJobHeader разбирает строку вида "<KIND> <jobID> [имя файла]"
+func parseJobHeader(line string) (kind, jobID, fileName string) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	kind = parts[0]
+	if len(parts) > 1 {
+		jobID = parts[1]
+	}
+	if len(parts) > 2 {
+		fileName = parts[2]
+	}
+	return kind, jobID, fileName
+}
+
+// writeStyleV1 передаёт стиль в старом формате
+func writeStyleV1(w io.Writer, data []byte) error {
+	if _, err := w.Write([]byte("STYLE\n")); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeImageV1 передаёт изображение в старом формате
+func writeImageV1(w io.Writer, jobID, fileName string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "IMAGE %s %s\n", jobID, fileName); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeResultV1 передаёт результат или ошибку в старом формате
+func writeResultV1(w io.Writer, jobID, fileName string, data []byte, errMsg string) error {
+	if data == nil {
+		_, err := fmt.Fprintf(w, "ERROR %s\n%s\n", jobID, errMsg)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "IMAGE %s %s\n", jobID, fileName); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,77 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// MDNSService — имя сервиса mDNS, под которым узлы курсовой находят друг друга в локальной сети
+const MDNSService = "coursework-style-transfer"
+
+// LANPeers — узлы, найденные mDNS в локальной сети. К каждому найденному узлу host
+// сразу подключается, поэтому его протоколы известны, а DHT добавляет его в таблицу маршрутизации.
+type LANPeers struct {
+	host  host.Host
+	mu    sync.Mutex
+	found []peerstore.AddrInfo // в порядке обнаружения
+}
+
+// StartMDNS объявляет узел в локальной сети и начинает искать в ней другие узлы
+func StartMDNS(h host.Host) (*LANPeers, mdns.Service, error) {
+	lan := &LANPeers{host: h}
+	service := mdns.NewMdnsService(h, MDNSService, lan)
+	if err := service.Start(); err != nil {
+		return nil, nil, fmt.Errorf("запуск mDNS: %w", err)
+	}
+	return lan, service, nil
+}
+
+// HandlePeerFound вызывается mDNS для каждого объявления узла в локальной сети
+func (l *LANPeers) HandlePeerFound(info peerstore.AddrInfo) {
+	l.mu.Lock()
+	known := slices.ContainsFunc(l.found, func(p peerstore.AddrInfo) bool { return p.ID == info.ID })
+	l.mu.Unlock()
+	if known {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.host.Connect(ctx, info); err != nil {
+		log.Printf("⚠️ Узел %s из локальной сети недоступен: %v\n", info.ID, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slices.ContainsFunc(l.found, func(p peerstore.AddrInfo) bool { return p.ID == info.ID }) {
+		return
+	}
+	l.found = append(l.found, info)
+	fmt.Println("🏠 Найден узел в локальной сети:", info.ID)
+}
+
+// Peers возвращает найденные узлы
+func (l *LANPeers) Peers() []peerstore.AddrInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.found)
+}
+
+// Schedulers возвращает найденные узлы, которые назначают процессоры (серверы), в порядке обнаружения
+func (l *LANPeers) Schedulers() []peerstore.AddrInfo {
+	var servers []peerstore.AddrInfo
+	for _, info := range l.Peers() {
+		if ok, _ := l.host.Peerstore().SupportsProtocols(info.ID, ProtoRequestPeer); len(ok) > 0 {
+			servers = append(servers, info)
+		}
+	}
+	return servers
+}
@@ -2,45 +2,205 @@ package p2p
 
 import (
 	"context"
-	"log"
+	"coursework_mimapr/internal/p2p/frame"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	peerstore "github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 
 	host "github.com/libp2p/go-libp2p/core/host"
+	network "github.com/libp2p/go-libp2p/core/network"
 )
 
-// Запрос назначения пира у сервера
-func RequestPeer(h host.Host, server peerstore.AddrInfo) (peerstore.ID, []ma.Multiaddr) {
-	stream, err := h.NewStream(context.Background(), server.ID, "/request-peer/1.0.0")
+var (
+	// ErrNoPeer — у сервера нет подходящих процессоров
+	ErrNoPeer = errors.New(CodeNoPeer + ": нет доступных получателей")
+	// ErrInsufficientTokens — на балансе инициатора не хватает токенов на задание
+	ErrInsufficientTokens = errors.New(CodeInsufficientTokens + ": недостаточно токенов для задания")
+)
+
+// RequestPeer запрашивает у текущего сервера процессор для задания jobID по протоколу "/request-peer/2.0.0".
+// Сервер резервирует стоимость задания на балансе инициатора; повторный запрос по тому же
+// заданию (процессор занят) токены не списывает. Процессоры из exclude задание не получат.
+// Непустой group — jobID является копией задания group: сервер не назначит её процессору,
+// получившему другую копию, а оплата будет ждать сверки результатов.
+// Старый сервер отвечает по "/request-peer/1.0.0" и не учитывает ни exclude, ни group.
+// Если сервер не отвечает, запрос повторяется на следующем сервере списка.
+// Возвращает и ID сервера, зарезервировавшего токены: итог задания сообщается ему.
+func RequestPeer(h host.Host, servers *Servers, jobID string, exclude []peerstore.ID, group string) (peerstore.AddrInfo, peerstore.ID, error) {
+	var info peerstore.AddrInfo
+	var reserved peerstore.ID
+	err := servers.do(func(server peerstore.AddrInfo) error {
+		var err error
+		info, err = requestPeer(h, server, jobID, exclude, group)
+		reserved = server.ID
+		return err
+	})
+	return info, reserved, err
+}
+
+// requestPeer запрашивает процессор у сервера server. Обрыв связи возвращается как ErrServerUnavailable.
+func requestPeer(h host.Host, server peerstore.AddrInfo, jobID string, exclude []peerstore.ID, group string) (peerstore.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stream, err := h.NewStream(ctx, server.ID, ProtoRequestPeer, ProtoRequestPeerV1)
 	if err != nil {
-		log.Println("❌ Ошибка запроса назначения:", err)
-		return "", nil
+		return peerstore.AddrInfo{}, fmt.Errorf("%w: запрос назначения: %v", ErrServerUnavailable, err)
 	}
 	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(time.Minute))
+
+	if stream.Protocol() == ProtoRequestPeerV1 {
+		return requestPeerV1(stream)
+	}
+
+	meta := map[string]string{MetaJob: jobID}
+	if len(exclude) > 0 {
+		ids := make([]string, len(exclude))
+		for i, id := range exclude {
+			ids[i] = id.String()
+		}
+		meta[MetaExclude] = strings.Join(ids, ",")
+	}
+	if group != "" {
+		meta[MetaGroup] = group
+	}
+	if err := frame.Write(stream, frame.New(frame.TypePeerRequest, meta, nil)); err != nil {
+		return peerstore.AddrInfo{}, fmt.Errorf("%w: запрос назначения: %v", ErrServerUnavailable, err)
+	}
+	f, err := frame.Read(stream)
+	if err != nil {
+		return peerstore.AddrInfo{}, fmt.Errorf("%w: чтение ответа от сервера: %v", ErrServerUnavailable, err)
+	}
+	switch f.Type {
+	case frame.TypePeer:
+	case frame.TypeError:
+		switch f.Get(MetaCode) {
+		case CodeNoPeer:
+			return peerstore.AddrInfo{}, ErrNoPeer
+		case CodeInsufficientTokens:
+			return peerstore.AddrInfo{}, fmt.Errorf("%w (баланс %s)", ErrInsufficientTokens, f.Get(MetaBalance))
+		}
+		return peerstore.AddrInfo{}, fmt.Errorf("сервер отклонил запрос: %s", f.Payload)
+	default:
+		return peerstore.AddrInfo{}, fmt.Errorf("неожиданный ответ сервера: %s", f.Type)
+	}
 
+	info, err := parsePeer(f.Get(MetaPeer), f.Get(MetaAddrs))
+	if err != nil {
+		return peerstore.AddrInfo{}, err
+	}
+	if balance := f.Get(MetaBalance); balance != "" {
+		fmt.Printf("🪙 Задание %s: токены зарезервированы, баланс %s\n", jobID, balance)
+	}
+	return info, nil
+}
+
+// requestPeerV1 читает текстовый ответ старого сервера: "id|addr,addr" или "NO_PEER"
+func requestPeerV1(stream network.Stream) (peerstore.AddrInfo, error) {
 	buf := make([]byte, 1024)
 	n, err := stream.Read(buf)
 	if err != nil {
-		log.Println("❌ Ошибка чтения ответа от сервера:", err)
-		return "", nil
+		return peerstore.AddrInfo{}, fmt.Errorf("%w: чтение ответа от сервера: %v", ErrServerUnavailable, err)
 	}
 	resp := string(buf[:n])
-	if resp == "NO_PEER" {
-		return "", nil
+	switch resp {
+	case CodeNoPeer:
+		return peerstore.AddrInfo{}, ErrNoPeer
+	case CodeInsufficientTokens:
+		return peerstore.AddrInfo{}, ErrInsufficientTokens
 	}
 	parts := strings.Split(resp, "|")
 	if len(parts) < 2 {
-		return "", nil
+		return peerstore.AddrInfo{}, fmt.Errorf("неверный ответ сервера: %q", resp)
+	}
+	return parsePeer(parts[0], parts[1])
+}
+
+// parsePeer собирает AddrInfo из ID и списка адресов через запятую
+func parsePeer(id, addrList string) (peerstore.AddrInfo, error) {
+	peerID, err := peerstore.Decode(id)
+	if err != nil {
+		return peerstore.AddrInfo{}, fmt.Errorf("неверный ID процессора %q: %w", id, err)
 	}
-	peerID, _ := peerstore.Decode(parts[0])
 	var addrs []ma.Multiaddr
-	for _, s := range strings.Split(parts[1], ",") {
+	for _, s := range strings.Split(addrList, ",") {
 		a, err := ma.NewMultiaddr(s)
 		if err == nil {
 			addrs = append(addrs, a)
 		}
 	}
-	return peerID, addrs
+	return peerstore.AddrInfo{ID: peerID, Addrs: addrs}, nil
+}
+
+// ReportJob сообщает серверу итог задания по протоколу "/job-report/1.0.0".
+// StatusDone от процессора — оплата ему зарезервированных токенов,
+// StatusFailed от процессора или инициатора — возврат токенов инициатору.
+// server — сервер, зарезервировавший токены задания (см. RequestPeer); пусто — текущий.
+func ReportJob(h host.Host, servers *Servers, server peerstore.ID, jobID, status, reason string) error {
+	return reportJob(h, servers, server, map[string]string{MetaJob: jobID, MetaStatus: status}, reason)
+}
+
+// ReportTimeout сообщает серверу, что процессор не прислал результат задания вовремя:
+// резерв возвращается инициатору, как при StatusFailed, а процессору засчитывается таймаут.
+// Старый сервер не знает MetaCause и просто возвращает резерв.
+func ReportTimeout(h host.Host, servers *Servers, server peerstore.ID, jobID, reason string) error {
+	meta := map[string]string{MetaJob: jobID, MetaStatus: StatusFailed, MetaCause: CauseTimeout}
+	return reportJob(h, servers, server, meta, reason)
+}
+
+// reportJob отправляет итог задания серверу reserved. Другой сервер резерва не знает, поэтому
+// если reserved не отвечает, итог повторяется, пока он не вернётся (ErrReportPending).
+// Без сервера (nil servers, например при поиске процессоров через DHT) токены не
+// резервируются, и сообщать итог некому.
+func reportJob(h host.Host, servers *Servers, reserved peerstore.ID, meta map[string]string, reason string) error {
+	if servers == nil {
+		return nil
+	}
+	return servers.doOn(reserved, meta[MetaJob], func(server peerstore.AddrInfo) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		stream, err := h.NewStream(ctx, server.ID, ProtoReport)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+		}
+		defer stream.Close()
+		stream.SetDeadline(time.Now().Add(time.Minute))
+
+		if err := frame.Write(stream, frame.New(frame.TypeReport, meta, []byte(reason))); err != nil {
+			return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+		}
+		f, err := frame.Expect(stream, frame.TypeAck)
+		if err != nil && f == nil {
+			return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+		}
+		return err
+	})
+}
+
+// Register сообщает серверу роль узла по протоколу "/register/1.0.0".
+// Задания назначаются только узлам с ролью "processor" или "all".
+// token — токен допуска для сервера в закрытом режиме; пусто — не предъявлять.
+func Register(h host.Host, server peerstore.AddrInfo, mode, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stream, err := h.NewStream(ctx, server.ID, ProtoRegister)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(time.Minute))
+
+	meta := map[string]string{MetaMode: mode}
+	if token != "" {
+		meta[MetaToken] = token
+	}
+	if err := frame.Write(stream, frame.New(frame.TypeRegister, meta, nil)); err != nil {
+		return err
+	}
+	_, err = frame.Expect(stream, frame.TypeAck)
+	return err
 }
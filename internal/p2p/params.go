@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/style"
+	"fmt"
+	"strconv"
+)
+
+// putParams записывает заданные поля params в метаданные кадра
+func putParams(meta map[string]string, params style.Params) {
+	if params.Epochs != 0 {
+		meta[MetaEpochs] = strconv.Itoa(params.Epochs)
+	}
+	if params.LR != 0 {
+		meta[MetaLR] = strconv.FormatFloat(params.LR, 'g', -1, 64)
+	}
+	if params.Alpha != 0 {
+		meta[MetaAlpha] = strconv.FormatFloat(params.Alpha, 'g', -1, 64)
+	}
+	if params.Beta != 0 {
+		meta[MetaBeta] = strconv.FormatFloat(params.Beta, 'g', -1, 64)
+	}
+	if params.Size != 0 {
+		meta[MetaSize] = strconv.Itoa(params.Size)
+	}
+	if params.KeepAspect {
+		meta[MetaKeepAspect] = "1"
+	}
+}
+
+// readParams разбирает параметры стилизации из метаданных кадра
+func readParams(f *frame.Frame) (style.Params, error) {
+	var params style.Params
+	ints := []struct {
+		key string
+		dst *int
+	}{{MetaEpochs, &params.Epochs}, {MetaSize, &params.Size}}
+	for _, field := range ints {
+		if v := f.Get(field.key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return params, fmt.Errorf("неверное значение %s=%q", field.key, v)
+			}
+			*field.dst = n
+		}
+	}
+	floats := []struct {
+		key string
+		dst *float64
+	}{{MetaLR, &params.LR}, {MetaAlpha, &params.Alpha}, {MetaBeta, &params.Beta}}
+	for _, field := range floats {
+		if v := f.Get(field.key); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return params, fmt.Errorf("неверное значение %s=%q", field.key, v)
+			}
+			*field.dst = x
+		}
+	}
+	params.KeepAspect = f.Get(MetaKeepAspect) == "1"
+	return params, params.Validate()
+}
+
+// putSizes записывает размеры результата в метаданные кадра
+func putSizes(meta map[string]string, sizes style.Result) {
+	if sizes == (style.Result{}) {
+		return
+	}
+	meta[MetaWidth] = strconv.Itoa(sizes.Width)
+	meta[MetaHeight] = strconv.Itoa(sizes.Height)
+	meta[MetaWorkWidth] = strconv.Itoa(sizes.WorkWidth)
+	meta[MetaWorkHeight] = strconv.Itoa(sizes.WorkHeight)
+}
+
+// readSizes читает размеры результата из метаданных кадра; от старых процессоров их нет
+func readSizes(f *frame.Frame) style.Result {
+	atoi := func(key string) int {
+		n, _ := strconv.Atoi(f.Get(key))
+		return n
+	}
+	return style.Result{
+		WorkWidth:  atoi(MetaWorkWidth),
+		WorkHeight: atoi(MetaWorkHeight),
+		Width:      atoi(MetaWidth),
+		Height:     atoi(MetaHeight),
+	}
+}
@@ -0,0 +1,49 @@
+package p2p
+
+import (
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/style"
+	"testing"
+)
+
+func TestReadParams(t *testing.T) {
+	tests := []struct {
+		name string
+		meta map[string]string
+		want style.Params
+		ok   bool
+	}{
+		{"без параметров", nil, style.Params{}, true},
+		{"все параметры", map[string]string{MetaEpochs: "200", MetaLR: "0.01", MetaAlpha: "1", MetaBeta: "100", MetaSize: "768", MetaKeepAspect: "1"},
+			style.Params{Epochs: 200, LR: 0.01, Alpha: 1, Beta: 100, Size: 768, KeepAspect: true}, true},
+		{"keep_aspect не 1", map[string]string{MetaKeepAspect: "yes"}, style.Params{}, true},
+		{"epochs не число", map[string]string{MetaEpochs: "many"}, style.Params{}, false},
+		{"size дробный", map[string]string{MetaSize: "512.5"}, style.Params{}, false},
+		{"lr не число", map[string]string{MetaLR: "fast"}, style.Params{}, false},
+		{"отрицательные epochs", map[string]string{MetaEpochs: "-5"}, style.Params{}, false},
+		{"NaN", map[string]string{MetaAlpha: "NaN"}, style.Params{}, false},
+		{"Inf", map[string]string{MetaBeta: "+Inf"}, style.Params{}, false},
+		{"переполнение", map[string]string{MetaEpochs: "99999999999999999999"}, style.Params{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readParams(frame.New(frame.TypeImage, tt.meta, nil))
+			if (err == nil) != tt.ok {
+				t.Fatalf("ошибка %v", err)
+			}
+			if tt.ok && got != tt.want {
+				t.Fatalf("прочитано %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParamsRoundTrip(t *testing.T) {
+	params := style.Params{Epochs: 150, LR: 0.002, Alpha: 4, Beta: 90, Size: 640, KeepAspect: true}
+	meta := make(map[string]string)
+	putParams(meta, params)
+	got, err := readParams(frame.New(frame.TypeImage, meta, nil))
+	if err != nil || got != params {
+		t.Fatalf("прочитано %+v (%v), ожидалось %+v", got, err, params)
+	}
+}
@@ -0,0 +1,89 @@
+package p2p
+
+// Идентификаторы протоколов обмена данными между узлами.
+// Версии /2.0.0 используют кадры из пакета frame, /1.0.0 — текстовый заголовок и данные до EOF.
+const (
+	ProtoStyle    = "/receive-style/2.0.0"
+	ProtoImage    = "/receive-image/2.0.0"
+	ProtoResult   = "/receive-image-result/2.0.0"
+	ProtoStyleV1  = "/receive-style/1.0.0"
+	ProtoImageV1  = "/receive-image/1.0.0"
+	ProtoResultV1 = "/receive-image-result/1.0.0"
+)
+
+// ProtoStylize — запрос/ответ в одном потоке: инициатор отправляет изображение, процессор
+// присылает keepalive-кадры TypeProgress и затем результат в тот же поток.
+// Не требует обратного соединения, поэтому работает для инициаторов за NAT и через relay.
+const ProtoStylize = "/stylize/1.0.0"
+
+// Служебные протоколы сервера (кадры frame)
+const (
+	ProtoHeartbeat     = "/heartbeat/1.0.0"
+	ProtoRegister      = "/register/1.0.0"
+	ProtoRequestPeer   = "/request-peer/2.0.0"
+	ProtoRequestPeerV1 = "/request-peer/1.0.0" // текстовый ответ "id|addr,addr" без учёта токенов
+	ProtoReport        = "/job-report/1.0.0"
+)
+
+// Ключи метаданных кадров
+const (
+	MetaJob        = "job"         // идентификатор задания
+	MetaName       = "name"        // исходное имя файла
+	MetaStyle      = "style"       // SHA-256 файла признаков стиля
+	MetaRetryAfter = "retry_after" // через сколько секунд повторить запрос к занятому процессору
+	MetaMode       = "mode"        // роль узла: initiator, processor или all
+	MetaPeer       = "peer"        // ID назначенного процессора
+	MetaAddrs      = "addrs"       // адреса назначенного процессора через запятую
+	MetaBalance    = "balance"     // баланс токенов инициатора после резерва
+	MetaCode       = "code"        // машинный код ошибки сервера
+	MetaStatus     = "status"      // итог задания: done или failed
+	MetaExclude    = "exclude"     // процессоры через запятую, которым задание не назначать
+	MetaState      = "state"       // стадия задания в keepalive: queued или running
+	MetaElapsed    = "elapsed"     // секунд с приёма задания
+	MetaGroup      = "group"       // задание, копией которого является запрос (избыточное выполнение)
+	MetaVerify     = "verify"      // "1" — результат оплачивается после сверки инициатором
+	MetaCause      = "cause"       // причина неудачи в отчёте, см. CauseTimeout
+	MetaToken      = "token"       // токен допуска к закрытому серверу в кадре регистрации
+	MetaKey        = "key"         // ключ задания (base64), которым зашифровано изображение
+	MetaReplyKey   = "reply_key"   // открытый ключ X25519 инициатора (base64) для шифрования результата
+	MetaSealed     = "sealed"      // "1" — результат зашифрован ключом MetaReplyKey
+	MetaEnvelope   = "envelope"    // подписанное процессором описание результата (Envelope в JSON)
+	MetaServer     = "server"      // ID сервера, зарезервировавшего токены задания: ему процессор сообщает итог
+)
+
+// Ключи параметров стилизации в кадре изображения (style.Params); отсутствующий ключ —
+// значение по умолчанию процессора
+const (
+	MetaEpochs = "epochs"
+	MetaLR     = "lr"
+	MetaAlpha  = "alpha"
+	MetaBeta   = "beta"
+	MetaSize   = "size"
+	// MetaKeepAspect = "1" — стилизовать с сохранением пропорций и вернуть исходное разрешение
+	MetaKeepAspect = "keep_aspect"
+)
+
+// Ключи размеров изображения в кадре результата (style.Result)
+const (
+	MetaWidth      = "width"       // ширина файла результата
+	MetaHeight     = "height"      // высота файла результата
+	MetaWorkWidth  = "work_width"  // ширина, на которой шла стилизация
+	MetaWorkHeight = "work_height" // высота, на которой шла стилизация
+)
+
+// Коды ошибок сервера в кадре TypeError (ключ MetaCode)
+const (
+	CodeNoPeer             = "NO_PEER"
+	CodeInsufficientTokens = "INSUFFICIENT_TOKENS"
+)
+
+// Итоги задания в отчёте серверу (ключ MetaStatus)
+const (
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusDisputed = "disputed" // результат не совпал с большинством копий, сообщает инициатор
+)
+
+// CauseTimeout — процессор не прислал результат за отведённое время (ключ MetaCause
+// в отчёте StatusFailed от инициатора); сервер учитывает это в репутации процессора
+const CauseTimeout = "timeout"
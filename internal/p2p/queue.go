@@ -0,0 +1,100 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// JobQueue — очередь заданий процессора: не больше workers стилизаций одновременно
+// и не больше depth заданий в ожидании. Переполненная очередь новые задания не принимает.
+type JobQueue struct {
+	tasks   chan func()
+	workers int
+
+	mu      sync.Mutex
+	active  int
+	avg     time.Duration // скользящее среднее длительности задания
+	handled int
+}
+
+// QueueStats — текущая загрузка очереди
+type QueueStats struct {
+	Workers     int
+	Depth       int
+	Queued      int
+	Active      int
+	AvgDuration time.Duration
+}
+
+// NewJobQueue запускает workers обработчиков с очередью глубиной depth
+func NewJobQueue(workers, depth int) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	q := &JobQueue{tasks: make(chan func(), depth), workers: workers}
+	for i := 0; i < workers; i++ {
+		go q.run()
+	}
+	return q
+}
+
+func (q *JobQueue) run() {
+	for task := range q.tasks {
+		q.mu.Lock()
+		q.active++
+		q.mu.Unlock()
+
+		start := time.Now()
+		task()
+		elapsed := time.Since(start)
+
+		q.mu.Lock()
+		q.active--
+		q.handled++
+		if q.handled == 1 {
+			q.avg = elapsed
+		} else {
+			q.avg = (q.avg*4 + elapsed) / 5
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Submit ставит задание в очередь. false — очередь заполнена, задание не принято.
+func (q *JobQueue) Submit(task func()) bool {
+	// При depth = 0 задание примет только свободный обработчик
+	select {
+	case q.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stats возвращает текущую загрузку очереди
+func (q *JobQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Workers:     q.workers,
+		Depth:       cap(q.tasks),
+		Queued:      len(q.tasks),
+		Active:      q.active,
+		AvgDuration: q.avg,
+	}
+}
+
+// RetryAfter оценивает, через сколько в очереди освободится место
+func (q *JobQueue) RetryAfter() time.Duration {
+	stats := q.Stats()
+	avg := stats.AvgDuration
+	if avg == 0 {
+		avg = 30 * time.Second
+	}
+	// Место появится, когда каждый обработчик закончит хотя бы одно задание
+	wait := avg * time.Duration(stats.Queued/stats.Workers+1)
+	return wait.Round(time.Second)
+}
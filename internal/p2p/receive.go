@@ -2,134 +2,571 @@ package p2p
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/seal"
 	"coursework_mimapr/internal/style"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	host "github.com/libp2p/go-libp2p/core/host"
 	network "github.com/libp2p/go-libp2p/core/network"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-var styleFile = "style.pt" // Файл, в котором будут признаки стиля
+// stylizeTimeout ограничивает время стилизации одного изображения
+const stylizeTimeout = 30 * time.Minute
 
-// Обработчик получения обработанных изображений по протоколу "/receive-image-result/1.0.0"
-func ReceiveProcessedImage(s network.Stream) {
-	defer s.Close()
-	peerID := s.Conn().RemotePeer().String()
-	timestamp := time.Now().UnixNano()
-	// Сохраняем в папку processed_images
-	dir := "processed_images"
-	os.MkdirAll(dir, 0755)
-	fileName := fmt.Sprintf("%s/processed_%s_%d.jpg", dir, peerID, timestamp)
-	file, err := os.Create(fileName)
+// Обработчик получения обработанных изображений по протоколу "/receive-image-result/2.0.0".
+// Результат сохраняется в каталог задания (или outDir) под исходным именем файла, состояние задания обновляется в jobs.
+func MakeReceiveResultHandler(jobs *JobTable, outDir string) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+
+		f, err := frame.Read(s)
+		if err != nil {
+			log.Println("❌ Ошибка чтения результата:", err)
+			return
+		}
+		switch f.Type {
+		case frame.TypeResult:
+			from, jobID := s.Conn().RemotePeer(), f.Get(MetaJob)
+			payload, env, err := openResult(jobs, from, jobID, f)
+			if err != nil {
+				failResult(jobs, from, jobID, err.Error())
+				return
+			}
+			saveResult(jobs, outDir, from, jobID, f.Get(MetaName), readSizes(f), env, bytes.NewReader(payload))
+		case frame.TypeError:
+			failResult(jobs, s.Conn().RemotePeer(), f.Get(MetaJob), string(f.Payload))
+		default:
+			log.Println("❌ Неожиданный тип кадра результата:", f.Type)
+		}
+	}
+}
+
+// saveResult сохраняет результат задания под именем из таблицы заданий (Job.FileName), а подпись
+// процессора env (если есть) — рядом в ProvenancePath. Результат принимается только от процессора
+// текущей попытки; имя fileName, которое вернул процессор, должно совпадать с отправленным.
+// true — задание выполнено.
+func saveResult(jobs *JobTable, outDir string, from peerstore.ID, jobID, fileName string, sizes style.Result, env *Envelope, data io.Reader) bool {
+	job, ok := jobs.Get(jobID)
+	if !ok {
+		log.Printf("⚠️ Результат для неизвестного задания %q от %s отброшен\n", jobID, from)
+		return false
+	}
+	if job.Peer != from {
+		log.Printf("⚠️ Результат задания %s от %s отброшен: задание передано %s\n", jobID, from, job.Peer)
+		return false
+	}
+	if fileName != "" && fileName != filepath.Base(job.InputPath) {
+		failResult(jobs, from, jobID, fmt.Sprintf("процессор вернул результат под чужим именем %q", fileName))
+		return false
+	}
+	if job.OutputDir != "" {
+		outDir = job.OutputDir
+	}
+	fileName = filepath.Join(outDir, job.FileName)
+	if err := SaveReaderToFile(bufio.NewReader(data), fileName); err != nil {
+		log.Println("❌ Ошибка сохранения результата:", err)
+		jobs.Fail(jobID, err.Error())
+		return false
+	}
+	if env == nil {
+		os.Remove(ProvenancePath(fileName)) // подпись прежнего результата с тем же именем
+	} else if err := saveProvenance(fileName, *env); err != nil {
+		log.Println("❌ Ошибка сохранения подписи результата:", err)
+		jobs.Fail(jobID, err.Error())
+		return false
+	}
+	jobs.Done(jobID, fileName, sizes)
+	if sizes != (style.Result{}) {
+		log.Printf("✅ Обработанный файл получен: %s (задание %s, %s)\n", fileName, jobID, sizes)
+	} else {
+		log.Printf("✅ Обработанный файл получен: %s (задание %s)\n", fileName, jobID)
+	}
+	log.Println("📊 Задания:", jobs.Summary())
+	return true
+}
+
+// openResult возвращает содержимое кадра результата и подписанное процессором описание.
+// Результат задания, отправленного с шифрованием, расшифровывается ключом задания;
+// незашифрованный результат такого задания не принимается. Подпись сверяется с тем,
+// что отправлено процессору; результат без подписи или с недействительной подписью
+// не принимается. Неподписанные результаты старых процессоров приходят только
+// по протоколу 1.0.0, см. MakeReceiveResultHandlerV1.
+func openResult(jobs *JobTable, from peerstore.ID, jobID string, f *frame.Frame) ([]byte, *Envelope, error) {
+	payload, err := resultPayload(jobs, jobID, f)
 	if err != nil {
-		log.Println("❌ Ошибка создания файла результата:", err)
-		return
+		return nil, nil, err
 	}
-	defer file.Close()
-	_, err = io.Copy(file, s)
+	raw := f.Get(MetaEnvelope)
+	if raw == "" {
+		return nil, nil, errors.New("результат не подписан процессором")
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return nil, nil, fmt.Errorf("неверная подпись результата: %w", err)
+	}
+	a, err := env.Open(from)
 	if err != nil {
-		log.Println("❌ Ошибка записи обработанного изображения:", err)
-		return
+		return nil, nil, err
+	}
+	sent, ok := jobs.expected(jobID)
+	if !ok {
+		return nil, nil, fmt.Errorf("нет данных об отправке задания %s для проверки подписи", jobID)
+	}
+	if err := checkAttestation(a, sent, style.Hash(payload)); err != nil {
+		return nil, nil, err
 	}
-	fmt.Println("✅ Обработанное изображение сохранено как:", fileName)
+	return payload, &env, nil
 }
 
-// ================= Режим процессора =================
-
-// Обработчик получения файла стиля по протоколу "/receive-style/1.0.0"
-func HandleReceiveStyle(s network.Stream) {
-	defer s.Close()
-	reader := bufio.NewReader(s)
-
-	// Читаем заголовок
-	header, err := reader.ReadString('\n')
+// resultPayload возвращает содержимое кадра результата, при необходимости расшифровывая его
+func resultPayload(jobs *JobTable, jobID string, f *frame.Frame) ([]byte, error) {
+	keys := jobs.keysOf(jobID)
+	sealed := f.Get(MetaSealed) == "1"
+	switch {
+	case keys == nil && !sealed:
+		return f.Payload, nil
+	case keys == nil:
+		return nil, errors.New("зашифрованный результат для задания без шифрования")
+	case !sealed:
+		return nil, errors.New("процессор вернул незашифрованный результат")
+	}
+	payload, err := seal.Open(keys.Reply, f.Payload)
 	if err != nil {
-		log.Println("❌ Ошибка чтения заголовка стиля:", err)
-		return
+		return nil, fmt.Errorf("расшифровка результата: %w", err)
 	}
-	header = strings.TrimSpace(header)
-	if header != "STYLE" {
-		log.Println("❌ Ожидался заголовок 'STYLE', получено:", header)
+	return payload, nil
+}
+
+// failResult отмечает неудачную попытку задания, о которой сообщил процессор.
+// Решение о повторной отправке принимает инициатор.
+func failResult(jobs *JobTable, from peerstore.ID, jobID, msg string) {
+	job, ok := jobs.Get(jobID)
+	if !ok {
+		log.Printf("⚠️ Ошибка для неизвестного задания %q: %s\n", jobID, msg)
 		return
 	}
-
-	// Сохраняем оставшиеся данные в файл
-	dir := "received_styles"
-	os.MkdirAll(dir, 0755)
-	fileName := fmt.Sprintf("%s/received_style_%d.pt", dir, time.Now().UnixNano())
-	err = SaveReaderToFile(reader, fileName)
-	if err != nil {
-		log.Println("❌ Ошибка сохранения файла стиля:", err)
+	if !jobs.AttemptFailed(jobID, from, msg) {
+		log.Printf("⚠️ Ошибка задания %s от %s проигнорирована: %s\n", jobID, from, msg)
 		return
 	}
-	fmt.Println("🎨 Файл стиля получен и сохранен как:", fileName)
-	// Обновляем локальный styleFile для обработки
-	styleFile = fileName
+	log.Printf("❌ Процессор сообщил об ошибке для %s (%s): %s\n", job.FileName, jobID, msg)
+	log.Println("📊 Задания:", jobs.Summary())
+}
+
+// ================= Режим процессора =================
+
+// Processor — всё, что нужно обработчикам режима процессора
+type Processor struct {
+	Host     host.Host
+	Servers  *Servers // серверы, которым сообщается итог заданий для расчёта токенов; nil — без сервера
+	Styles   *style.Store
+	Stylizer style.Stylizer
+	Queue    *JobQueue
+	Limits   style.Limits // границы параметров стилизации, которые может запросить инициатор
 }
 
-// Обработчик получения изображения для стилизации по протоколу "/receive-image/1.0.0"
-func MakeReceiveImageHandler(h host.Host) network.StreamHandler {
+// Обработчик получения файла стиля по протоколу "/receive-style/2.0.0".
+// Стиль сохраняется в styles под своим SHA-256 и больше не перезаписывается.
+func MakeReceiveStyleHandler(styles *style.Store) network.StreamHandler {
 	return func(s network.Stream) {
 		defer s.Close()
-		// Оборачиваем поток в bufio.Reader
-		reader := bufio.NewReader(s)
-		// Читаем заголовок (ожидается "IMAGE")
-		header, err := reader.ReadString('\n')
+
+		f, err := frame.Expect(s, frame.TypeStyle)
 		if err != nil {
-			log.Println("❌ Ошибка чтения заголовка:", err)
+			log.Println("❌ Ошибка чтения кадра стиля:", err)
 			return
 		}
-		header = strings.TrimSpace(header)
-		if header != "IMAGE" {
-			log.Println("❌ Неверный заголовок, ожидается IMAGE, получено:", header)
+		hash := style.Hash(f.Payload)
+		if want := f.Get(MetaStyle); want != "" && want != hash {
+			log.Printf("❌ Хэш стиля не совпадает: заявлен %s, получен %s\n", want, hash)
+			writeError(s, "", "хэш стиля не совпадает с содержимым")
 			return
 		}
-
-		// Сохраняем оставшиеся данные в файл, создавая уникальное имя в папке "received_images"
-		dir := "received_images"
-		os.MkdirAll(dir, 0755)
-		tmpIn := fmt.Sprintf("%s/received_%d.jpg", dir, time.Now().UnixNano())
-		err = SaveReaderToFile(reader, tmpIn)
-		if err != nil {
-			log.Println("❌ Ошибка сохранения полученного изображения:", err)
+		if _, err := styles.Put(f.Payload); err != nil {
+			log.Println("❌ Ошибка сохранения файла стиля:", err)
+			writeError(s, "", "не удалось сохранить стиль")
 			return
 		}
-		fmt.Println("📥 Изображение получено:", tmpIn)
+		if err := frame.Write(s, frame.New(frame.TypeAck, map[string]string{MetaStyle: hash}, nil)); err != nil {
+			log.Println("❌ Ошибка подтверждения стиля:", err)
+		}
+		fmt.Println("🎨 Файл стиля получен и сохранен как:", styles.Path(hash))
+	}
+}
 
-		// Запускаем стилизацию с использованием локального styleFile
-		dirOut := "processed_images"
-		os.MkdirAll(dirOut, 0755)
-		tmpOut := fmt.Sprintf("%s/styled_%d.jpg", dirOut, time.Now().UnixNano())
-		// Сохраняем путь к адресам
-		addrs := []ma.Multiaddr{s.Conn().RemoteMultiaddr()}
+// Обработчик получения изображения для стилизации по протоколу "/receive-image/2.0.0".
+// Если стиля из запроса нет в p.Styles, инициатор получает "missing style <hash>" и должен загрузить его.
+// Если очередь p.Queue заполнена, изображение не принимается: инициатор получает "busy" со временем повтора.
+func MakeReceiveImageHandler(p *Processor) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
 
-		cmd := exec.Command(style.GetPythonCommand(), "style_transfer.py", "stylize", tmpIn, styleFile, tmpOut)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Println("⏳ Запуск стилизации для", tmpIn)
-		if err := cmd.Run(); err != nil {
-			log.Println("❌ Ошибка стилизации:", err)
-			SendProcessedImage(h, s.Conn().RemotePeer(), addrs, "", true, "Ошибка стилизации изображения")
-			os.Remove(tmpIn)
+		task, ok := p.acceptImage(s)
+		if !ok {
 			return
 		}
-		fmt.Println("🖼 Стилизация завершена:", tmpOut)
+		initiator := s.Conn().RemotePeer()
+		addrs := []ma.Multiaddr{s.Conn().RemoteMultiaddr()}
+		accepted := p.Queue.Submit(func() {
+			p.stylizeAndReply(initiator, addrs, task)
+		})
+		if !accepted {
+			os.Remove(task.TmpIn)
+			p.replyBusy(s, task.JobID)
+			return
+		}
+		if err := frame.Write(s, frame.New(frame.TypeAck, map[string]string{MetaJob: task.JobID}, nil)); err != nil {
+			log.Println("❌ Ошибка подтверждения изображения:", err)
+		}
+	}
+}
+
+// imageTask — принятое процессором изображение и параметры его стилизации
+type imageTask struct {
+	JobID     string
+	Initiator peerstore.ID // идентификаторы заданий выбирают инициаторы, поэтому файлы заданий различаются и по нему
+	FileName  string
+	TmpIn     string // временный файл с изображением
+	StylePath string // файл признаков стиля
+	StyleHash string // SHA-256 файла признаков стиля
+	Params    style.Params
+	Verify    bool         // оплату подтверждает инициатор после сверки копий, процессор сообщает только об ошибках
+	Server    peerstore.ID // сервер, зарезервировавший токены задания; пусто — текущий
+	// Key — ключ задания, которым зашифрован TmpIn; nil — изображение не зашифровано.
+	// Хранится только в памяти процессора на время задания.
+	Key     []byte
+	ReplyTo *ecdh.PublicKey // ключ инициатора, которому шифруется результат
+}
+
+// fileKey — часть имён файлов задания на диске процессора: инициатор и идентификатор задания
+func (t imageTask) fileKey() string {
+	return t.Initiator.String() + "_" + t.JobID
+}
+
+// sealed сообщает, что изображение и результат задания передаются зашифрованными
+func (t imageTask) sealed() bool {
+	return t.Key != nil
+}
 
-		// Отправляем результат
-		SendProcessedImage(h, s.Conn().RemotePeer(), addrs, tmpOut, false, "")
+// acceptImage читает кадр изображения и сохраняет его во временный файл.
+// Параметры стилизации дополняются значениями по умолчанию и проверяются по p.Limits.
+// Если стиля нет или запрос некорректен, отвечает инициатору сам и возвращает ok=false.
+func (p *Processor) acceptImage(s network.Stream) (task imageTask, ok bool) {
+	f, err := frame.Expect(s, frame.TypeImage)
+	if err != nil {
+		log.Println("❌ Ошибка чтения кадра изображения:", err)
+		return
+	}
+	jobID := f.Get(MetaJob)
+	if err := CheckJobID(jobID); err != nil {
+		log.Printf("❌ Запрос изображения отклонён (задание %q): %v\n", jobID, err)
+		writeError(s, "", err.Error())
+		return
+	}
+	params, err := readParams(f)
+	if err == nil {
+		params = params.Or(style.DefaultParams)
+		err = p.Limits.Check(params)
+	}
+	if err != nil {
+		log.Printf("❌ Задание %s отклонено: %v\n", jobID, err)
+		writeError(s, jobID, err.Error())
+		return
+	}
+	key, replyTo, err := readKeys(f)
+	if err != nil {
+		log.Printf("❌ Задание %s отклонено: %v\n", jobID, err)
+		writeError(s, jobID, err.Error())
+		return
+	}
+	styleHash := f.Get(MetaStyle)
+	if !p.Styles.Has(styleHash) {
+		fmt.Printf("🎨 Стиль %s отсутствует, запрашиваем у инициатора (задание %s)\n", styleHash, jobID)
+		meta := map[string]string{MetaJob: jobID, MetaStyle: styleHash}
+		_ = frame.Write(s, frame.New(frame.TypeMissingStyle, meta, []byte("missing style "+styleHash)))
+		return
+	}
+	ext := imageExt(f.Get(MetaName))
+	if key != nil {
+		ext = sealedExt
+	}
+	from := s.Conn().RemotePeer()
+	tmpIn, fileName, err := saveIncomingImage(from, jobID, f.Get(MetaName), ext, bytes.NewReader(f.Payload))
+	if err != nil {
+		log.Println("❌ Ошибка сохранения полученного изображения:", err)
+		writeError(s, jobID, "не удалось сохранить изображение")
+		return
+	}
+	task = imageTask{
+		JobID:     jobID,
+		Initiator: from,
+		FileName:  fileName,
+		TmpIn:     tmpIn,
+		StylePath: p.Styles.Path(styleHash),
+		StyleHash: styleHash,
+		Params:    params,
+		Verify:    f.Get(MetaVerify) == "1",
+		Server:    readServer(f),
+		Key:       key,
+		ReplyTo:   replyTo,
+	}
+	return task, true
+}
+
+// readServer читает сервер, зарезервировавший токены задания. Без него (или с неверным ID)
+// итог сообщается текущему серверу.
+func readServer(f *frame.Frame) peerstore.ID {
+	id, err := peerstore.Decode(f.Get(MetaServer))
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// readKeys читает ключи зашифрованного задания. Без ключей возвращает nil: задание не зашифровано.
+// Зашифрованное задание принимается, только если есть каталог в памяти для расшифровки.
+func readKeys(f *frame.Frame) (key []byte, replyTo *ecdh.PublicKey, err error) {
+	rawKey, rawReply := f.Get(MetaKey), f.Get(MetaReplyKey)
+	if rawKey == "" && rawReply == "" {
+		return nil, nil, nil
+	}
+	if key, err = base64.StdEncoding.DecodeString(rawKey); err != nil || len(key) != seal.KeySize {
+		return nil, nil, errors.New("неверный ключ задания")
+	}
+	reply, err := base64.StdEncoding.DecodeString(rawReply)
+	if err == nil {
+		replyTo, err = seal.ParsePublicKey(reply)
+	}
+	if err != nil {
+		return nil, nil, errors.New("неверный ключ инициатора для результата")
+	}
+	if _, err := seal.Tmpfs(); err != nil {
+		return nil, nil, err
+	}
+	return key, replyTo, nil
+}
+
+// replyBusy сообщает инициатору, что очередь заполнена и когда повторить
+func (p *Processor) replyBusy(s network.Stream, jobID string) {
+	retryAfter := p.Queue.RetryAfter()
+	fmt.Printf("🚦 Очередь заполнена, задание %s отклонено (повтор через %s)\n", jobID, retryAfter)
+	meta := map[string]string{MetaJob: jobID, MetaRetryAfter: strconv.Itoa(int(retryAfter.Seconds()))}
+	_ = frame.Write(s, frame.New(frame.TypeBusy, meta, []byte("busy, retry after "+retryAfter.String())))
+}
+
+// saveIncomingImage сохраняет изображение задания jobID от инициатора from в папку "received_images"
+// с расширением ext. jobID должен быть проверен CheckJobID.
+func saveIncomingImage(from peerstore.ID, jobID, fileName, ext string, r io.Reader) (tmpIn, name string, err error) {
+	name = filepath.Base(fileName)
+	dir := "received_images"
+	os.MkdirAll(dir, 0755)
+	tmpIn = fmt.Sprintf("%s/received_%s_%s%s", dir, from, jobID, ext)
+	if err := SaveReaderToFile(bufio.NewReader(r), tmpIn); err != nil {
+		return "", "", err
+	}
+	fmt.Printf("📥 Изображение получено: %s (задание %s, файл %s)\n", tmpIn, jobID, name)
+	return tmpIn, name, nil
+}
+
+// stylizeAndReply стилизует изображение задания, отправляет результат инициатору
+// обратным соединением и сообщает серверу итог задания: успех оплачивается,
+// ошибка возвращает токены инициатору.
+func (p *Processor) stylizeAndReply(initiator peerstore.ID, addrs []ma.Multiaddr, task imageTask) {
+	jobID, fileName := task.JobID, task.FileName
+	res, err := p.stylize(context.Background(), task)
+	defer os.Remove(res.Path)
+	if err != nil {
+		SendProcessedImage(p.Host, initiator, addrs, jobID, fileName, ResultFile{}, "Ошибка стилизации изображения")
+		p.report(task, StatusFailed, err.Error())
+		return
+	}
+
+	// Отправляем результат
+	if err := SendProcessedImage(p.Host, initiator, addrs, jobID, fileName, res, ""); err != nil {
+		p.report(task, StatusFailed, "результат не доставлен: "+err.Error())
+		return
+	}
+	p.reportDone(task)
+}
+
+// stylize стилизует изображение задания и возвращает файл результата с размерами и подписанным
+// описанием. task.TmpIn удаляется; результат удаляет вызывающий после отправки (путь
+// возвращается и при ошибке). Результат зашифрованного задания зашифрован ключом инициатора.
+func (p *Processor) stylize(ctx context.Context, task imageTask) (ResultFile, error) {
+	defer os.Remove(task.TmpIn)
+	// Результат сохраняем в формате исходного файла, чтобы инициатор мог использовать его имя.
+	dirOut := "processed_images"
+	os.MkdirAll(dirOut, 0755)
+	if task.sealed() {
+		return p.stylizeSealed(ctx, task, fmt.Sprintf("%s/styled_%s%s", dirOut, task.fileKey(), sealedExt))
+	}
+	res := ResultFile{Path: fmt.Sprintf("%s/styled_%s%s", dirOut, task.fileKey(), imageExt(task.FileName))}
+	var err error
+	if res.Sizes, err = p.run(ctx, task, task.TmpIn, res.Path); err != nil {
+		return res, err
+	}
+	input, err := style.HashFile(task.TmpIn)
+	if err != nil {
+		return res, err
+	}
+	output, err := style.HashFile(res.Path)
+	if err != nil {
+		return res, err
+	}
+	res.Envelope, err = p.attest(task, input, output)
+	return res, err
+}
+
+// stylizeSealed стилизует зашифрованное изображение. Расшифрованные вход и результат существуют
+// только в каталоге в памяти (seal.Tmpfs) на время стилизации, на диск sealedOut попадает
+// только результат, зашифрованный ключом инициатора.
+func (p *Processor) stylizeSealed(ctx context.Context, task imageTask, sealedOut string) (ResultFile, error) {
+	res := ResultFile{Path: sealedOut, Sealed: true}
+	fail := func(err error) (ResultFile, error) {
+		log.Printf("❌ Ошибка зашифрованного задания %s: %v\n", task.JobID, err)
+		return res, err
+	}
+	dir, err := seal.Tmpfs()
+	if err != nil {
+		return fail(err)
+	}
+	ciphertext, err := os.ReadFile(task.TmpIn)
+	if err != nil {
+		return fail(err)
+	}
+	plaintext, err := seal.Decrypt(task.Key, ciphertext)
+	if err != nil {
+		return fail(fmt.Errorf("расшифровка изображения: %w", err))
+	}
+	work, err := os.MkdirTemp(dir, "stylize_"+task.fileKey()+"_")
+	if err != nil {
+		return fail(err)
+	}
+	defer os.RemoveAll(work)
+	in := filepath.Join(work, "in"+imageExt(task.FileName))
+	out := filepath.Join(work, "out"+imageExt(task.FileName))
+	if err := os.WriteFile(in, plaintext, 0600); err != nil {
+		return fail(err)
+	}
+
+	if res.Sizes, err = p.run(ctx, task, in, out); err != nil {
+		return res, err
+	}
+	result, err := os.ReadFile(out)
+	if err != nil {
+		return fail(err)
+	}
+	if res.Envelope, err = p.attest(task, style.Hash(plaintext), style.Hash(result)); err != nil {
+		return fail(err)
+	}
+	sealed, err := seal.SealTo(task.ReplyTo, result)
+	if err != nil {
+		return fail(fmt.Errorf("шифрование результата: %w", err))
+	}
+	if err := os.WriteFile(sealedOut, sealed, 0600); err != nil {
+		return fail(err)
+	}
+	fmt.Println("🔐 Результат зашифрован ключом инициатора:", sealedOut)
+	return res, nil
+}
+
+// run запускает стилизацию файла in в out с параметрами задания
+func (p *Processor) run(ctx context.Context, task imageTask, in, out string) (style.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, stylizeTimeout)
+	defer cancel()
+	fmt.Println("⏳ Запуск стилизации для", task.TmpIn, task.Params)
+	sizes, err := p.Stylizer.Stylize(ctx, in, task.StylePath, out, task.Params)
+	if err != nil {
+		log.Println("❌ Ошибка стилизации:", err)
+		return sizes, err
+	}
+	fmt.Println("🖼 Стилизация завершена:", task.JobID, sizes)
+	return sizes, nil
+}
+
+// attest подписывает ключом процессора описание результата задания с хэшами
+// полученного изображения input и результата output (оба до шифрования)
+func (p *Processor) attest(task imageTask, input, output string) (string, error) {
+	a := Attestation{
+		JobID:      task.JobID,
+		Processor:  p.Host.ID().String(),
+		InputHash:  input,
+		StyleHash:  task.StyleHash,
+		Params:     task.Params,
+		OutputHash: output,
+		Created:    time.Now().UTC(),
+	}
+	env, err := SignAttestation(p.Host.Peerstore().PrivKey(p.Host.ID()), a)
+	if err != nil {
+		return "", fmt.Errorf("подпись результата: %w", err)
+	}
+	data, err := json.Marshal(env)
+	return string(data), err
+}
+
+// reportDone сообщает серверу о выполненном задании. Копии задания для сверки
+// оплачивает инициатор, поэтому о них процессор не сообщает.
+func (p *Processor) reportDone(task imageTask) {
+	if task.Verify {
+		fmt.Printf("🔍 Задание %s будет оплачено после сверки результатов инициатором\n", task.JobID)
+		return
+	}
+	p.report(task, StatusDone, "")
+}
+
+// report сообщает итог задания серверу, зарезервировавшему за него токены. Задания от
+// инициаторов протокола 1.0.0 не резервируют токены, поэтому сервер может ответить, что резерва нет.
+func (p *Processor) report(task imageTask, status, reason string) {
+	if p.Servers == nil {
+		return
+	}
+	jobID := task.JobID
+	err := ReportJob(p.Host, p.Servers, task.Server, jobID, status, reason)
+	switch {
+	case errors.Is(err, ErrReportPending):
+		log.Printf("⏳ Итог задания %s (%s) будет отправлен, когда сервер %s вернётся\n", jobID, status, task.Server)
+	case err != nil:
+		log.Printf("⚠️ Сервер не принял итог задания %s (%s): %v\n", jobID, status, err)
+	default:
+		fmt.Printf("🪙 Итог задания %s отправлен серверу: %s\n", jobID, status)
+	}
+}
+
+// sealedExt — расширение зашифрованных файлов заданий на диске процессора
+const sealedExt = ".enc"
+
+// imageExt возвращает расширение файла изображения (по умолчанию .jpg)
+func imageExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ".jpg"
+	}
+	return ext
+}
 
-		// Удаляем временные файлы
-		os.Remove(tmpIn)
-		os.Remove(tmpOut)
+// writeError отправляет кадр с ошибкой в поток
+func writeError(w io.Writer, jobID, msg string) {
+	meta := map[string]string{}
+	if jobID != "" {
+		meta[MetaJob] = jobID
 	}
+	_ = frame.Write(w, frame.New(frame.TypeError, meta, []byte(msg)))
 }
 
 // SaveStreamToFile читает весь поток и сохраняет его в указанный файл.
@@ -2,117 +2,271 @@ package p2p
 
 import (
 	"context"
+	"coursework_mimapr/internal/p2p/frame"
+	"coursework_mimapr/internal/seal"
+	"coursework_mimapr/internal/style"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	peerstore "github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 
 	host "github.com/libp2p/go-libp2p/core/host"
+	network "github.com/libp2p/go-libp2p/core/network"
 )
 
-// Отправка файла стиля по протоколу "/receive-style/1.0.0"
-func SendStyle(h host.Host, receiver peerstore.AddrInfo, stylePath string) {
-	file, err := os.Open(stylePath)
+// ackTimeout — сколько ждать подтверждения приёма от процессора
+const ackTimeout = time.Minute
+
+// ErrMissingStyle — у процессора нет стиля с хэшем из запроса
+var ErrMissingStyle = errors.New("missing style")
+
+// BusyError — очередь процессора заполнена, повторить можно через RetryAfter
+type BusyError struct {
+	Peer       peerstore.ID
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("процессор %s занят, повторить через %s", e.Peer, e.RetryAfter)
+}
+
+// ImageRequest описывает одно изображение, отправляемое процессору
+type ImageRequest struct {
+	JobID     string
+	ImagePath string
+	StylePath string       // файл признаков стиля, загружается только по запросу процессора
+	StyleHash string       // SHA-256 файла StylePath
+	Params    style.Params // параметры стилизации; нулевые поля — по умолчанию процессора
+	Group     string       // исходное задание, если это одна из его копий для сверки результатов
+	Server    peerstore.ID // сервер, зарезервировавший токены задания; процессор сообщит итог ему
+	Encrypt   bool         // шифровать изображение и результат ключами задания (см. seal)
+}
+
+// Отправка файла стиля по протоколу "/receive-style/2.0.0" (или 1.0.0 для старых узлов)
+func SendStyle(h host.Host, receiver peerstore.AddrInfo, stylePath string) error {
+	data, err := os.ReadFile(stylePath)
 	if err != nil {
-		log.Println("❌ Ошибка открытия файла стиля:", err)
-		return
+		return fmt.Errorf("открытие файла стиля: %w", err)
 	}
-	defer file.Close()
-	stream, err := h.NewStream(context.Background(), receiver.ID, "/receive-style/1.0.0")
+	stream, err := h.NewStream(context.Background(), receiver.ID, ProtoStyle, ProtoStyleV1)
 	if err != nil {
-		log.Println("❌ Ошибка соединения для отправки стиля:", err)
-		return
+		return fmt.Errorf("соединение для отправки стиля: %w", err)
 	}
 	defer stream.Close()
-	_, err = stream.Write([]byte("STYLE\n"))
-	if err != nil {
-		log.Println("❌ Ошибка отправки заголовка стиля:", err)
-		return
+
+	if stream.Protocol() == ProtoStyleV1 {
+		err = writeStyleV1(stream, data)
+	} else {
+		meta := map[string]string{MetaStyle: style.Hash(data)}
+		err = sendFrameAwaitAck(stream, frame.New(frame.TypeStyle, meta, data))
 	}
-	_, err = io.Copy(stream, file)
 	if err != nil {
-		log.Println("❌ Ошибка отправки файла стиля:", err)
-		return
+		return fmt.Errorf("отправка файла стиля: %w", err)
 	}
 	fmt.Println("✅ Признаки стиля отправлены получателю:", receiver.ID)
+	return nil
 }
 
-// Отправка изображения по протоколу "/receive-image/1.0.0"
-func SendImage(h host.Host, receiver peerstore.AddrInfo, imagePath string) {
-	file, err := os.Open(imagePath)
+// Отправка изображения по протоколу "/stylize/1.0.0" (или "/receive-image/2.0.0" и 1.0.0
+// для старых узлов). По "/stylize/1.0.0" результат приходит в тот же поток и записывается в jobs.
+// Если процессор ответил "missing style", стиль загружается и изображение отправляется повторно.
+func SendImage(h host.Host, receiver peerstore.AddrInfo, req ImageRequest, jobs *JobTable) error {
+	err := sendImageOnce(h, receiver, req, jobs)
+	if !errors.Is(err, ErrMissingStyle) {
+		return err
+	}
+	fmt.Printf("🎨 У %s нет стиля %s, загружаем...\n", receiver.ID, req.StyleHash)
+	if err := SendStyle(h, receiver, req.StylePath); err != nil {
+		return err
+	}
+	return sendImageOnce(h, receiver, req, jobs)
+}
+
+func sendImageOnce(h host.Host, receiver peerstore.AddrInfo, req ImageRequest, jobs *JobTable) error {
+	data, err := os.ReadFile(req.ImagePath)
 	if err != nil {
-		log.Println("❌ Ошибка открытия файла изображения:", err)
-		return
+		return fmt.Errorf("открытие файла изображения: %w", err)
 	}
-	defer file.Close()
-	stream, err := h.NewStream(context.Background(), receiver.ID, "/receive-image/1.0.0")
+	stream, err := h.NewStream(context.Background(), receiver.ID, ProtoStylize, ProtoImage, ProtoImageV1)
 	if err != nil {
-		log.Println("❌ Ошибка соединения для отправки изображения:", err)
-		return
+		return fmt.Errorf("соединение для отправки изображения: %w", err)
+	}
+	// Поток "/stylize/1.0.0" остаётся открытым до получения результата
+	keepOpen := false
+	defer func() {
+		if !keepOpen {
+			stream.Close()
+		}
+	}()
+
+	fileName := filepath.Base(req.ImagePath)
+	meta := map[string]string{MetaJob: req.JobID, MetaName: fileName, MetaStyle: req.StyleHash}
+	jobs.expect(req.JobID, Attestation{JobID: req.JobID, InputHash: style.Hash(data), StyleHash: req.StyleHash, Params: req.Params})
+	if req.Encrypt {
+		if stream.Protocol() == ProtoImageV1 {
+			return errors.New("процессор протокола 1.0.0 не поддерживает шифрование")
+		}
+		if data, err = sealImage(jobs, req.JobID, data, meta); err != nil {
+			return fmt.Errorf("шифрование изображения: %w", err)
+		}
+	}
+	if stream.Protocol() == ProtoImageV1 {
+		// Старый процессор не знает хэшей и использует последний присланный стиль
+		if err := SendStyle(h, receiver, req.StylePath); err != nil {
+			return err
+		}
+		err = writeImageV1(stream, req.JobID, fileName, data)
+	} else {
+		putParams(meta, req.Params)
+		if req.Group != "" {
+			meta[MetaVerify] = "1"
+		}
+		if req.Server != "" {
+			meta[MetaServer] = req.Server.String()
+		}
+		err = sendFrameAwaitAck(stream, frame.New(frame.TypeImage, meta, data))
+	}
+	var busy *BusyError
+	if errors.As(err, &busy) {
+		busy.Peer = receiver.ID
+		return err
+	}
+	if errors.Is(err, ErrMissingStyle) {
+		return err
 	}
-	defer stream.Close()
-	_, err = stream.Write([]byte("IMAGE\n"))
 	if err != nil {
-		log.Println("❌ Ошибка отправки заголовка изображения:", err)
-		return
+		return fmt.Errorf("отправка изображения: %w", err)
+	}
+	fmt.Println("✅ Изображение успешно отправлено:", fileName, "задание", req.JobID)
+	if stream.Protocol() == ProtoStylize {
+		keepOpen = true
+		go receiveStylizeResult(stream, jobs, req.JobID)
 	}
-	_, err = io.Copy(stream, file)
+	return nil
+}
+
+// sealImage шифрует изображение ключом задания и добавляет в meta ключ задания
+// и открытый ключ, которому процессор зашифрует результат
+func sealImage(jobs *JobTable, jobID string, data []byte, meta map[string]string) ([]byte, error) {
+	keys, err := jobs.Keys(jobID)
 	if err != nil {
-		log.Println("❌ Ошибка отправки изображения:", err)
-		return
+		return nil, err
+	}
+	sealed, err := seal.Encrypt(keys.Content, data)
+	if err != nil {
+		return nil, err
+	}
+	meta[MetaKey] = base64.StdEncoding.EncodeToString(keys.Content)
+	meta[MetaReplyKey] = base64.StdEncoding.EncodeToString(keys.Reply.PublicKey().Bytes())
+	return sealed, nil
+}
+
+// sendFrameAwaitAck пишет кадр и ждёт подтверждения от получателя
+func sendFrameAwaitAck(s network.Stream, f *frame.Frame) error {
+	if err := frame.Write(s, f); err != nil {
+		return err
+	}
+	s.SetReadDeadline(time.Now().Add(ackTimeout))
+	reply, err := frame.Expect(s, frame.TypeAck)
+	if reply != nil {
+		switch reply.Type {
+		case frame.TypeMissingStyle:
+			return fmt.Errorf("%w %s", ErrMissingStyle, reply.Get(MetaStyle))
+		case frame.TypeBusy:
+			seconds, _ := strconv.Atoi(reply.Get(MetaRetryAfter))
+			return &BusyError{RetryAfter: time.Duration(seconds) * time.Second}
+		}
+	}
+	return err
+}
+
+// ResultFile — результат задания на стороне процессора
+type ResultFile struct {
+	Path     string       // файл результата; пусто — результата нет
+	Sizes    style.Result // рабочий и итоговый размеры
+	Sealed   bool         // файл зашифрован ключом инициатора
+	Envelope string       // подписанное описание результата (Envelope в JSON)
+}
+
+// putResultMeta записывает в метаданные кадра результата размеры, признак шифрования и подпись
+func putResultMeta(meta map[string]string, res ResultFile) {
+	putSizes(meta, res.Sizes)
+	if res.Sealed {
+		meta[MetaSealed] = "1"
+	}
+	if res.Envelope != "" {
+		meta[MetaEnvelope] = res.Envelope
 	}
-	fmt.Println("✅ Изображение успешно отправлено:", filepath.Base(imagePath))
 }
 
-// Функция отправки обработанного изображения обратно отправителю (в режиме процессора)
-func SendProcessedImage(h host.Host, receiver peerstore.ID, addrs []ma.Multiaddr, filePath string, failed bool, errMsg string) {
+// Функция отправки обработанного изображения обратно отправителю (в режиме процессора).
+// jobID и fileName берутся из исходного запроса и возвращаются инициатору вместе с res.
+// Если файла результата нет, инициатор получает ошибку errMsg. Ошибка означает, что инициатор не получил результат.
+func SendProcessedImage(h host.Host, receiver peerstore.ID, addrs []ma.Multiaddr, jobID, fileName string, res ResultFile, errMsg string) error {
 	receiverInfo := peerstore.AddrInfo{ID: receiver, Addrs: addrs}
 	h.Peerstore().AddAddrs(receiverInfo.ID, receiverInfo.Addrs, time.Minute)
 
 	if err := h.Connect(context.Background(), receiverInfo); err != nil {
 		log.Println("❌ Ошибка подключения к получателю:", err)
-		return
+		return err
 	}
 
-	stream, err := h.NewStream(context.Background(), receiver, "/receive-image-result/1.0.0")
+	stream, err := h.NewStream(context.Background(), receiver, ProtoResult, ProtoResultV1)
 	if err != nil {
 		log.Println("❌ Ошибка установления потока:", err)
-		return
+		return err
 	}
 	defer stream.Close()
+	legacy := stream.Protocol() == ProtoResultV1
+
+	sendError := func(msg string) error {
+		if legacy {
+			return writeResultV1(stream, jobID, fileName, nil, msg)
+		}
+		writeError(stream, jobID, msg)
+		return nil
+	}
 
 	// Обработка ошибок передачи
-	if failed || filePath == "" {
-		_, _ = stream.Write([]byte("ERROR\n" + errMsg + "\n"))
+	filePath := res.Path
+	if filePath == "" {
 		log.Println("⚠️ Отправлено сообщение об ошибке:", errMsg)
-		return
+		return sendError(errMsg)
+	}
+	if res.Sealed && legacy {
+		sendError("инициатор протокола 1.0.0 не принимает зашифрованный результат")
+		return errors.New("инициатор не поддерживает шифрование")
 	}
 
-	// Проверка на существование файла
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		errMsg := fmt.Sprintf("Файл результата не найден: %s", filePath)
-		_, _ = stream.Write([]byte("ERROR\n" + errMsg + "\n"))
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		sendError(fmt.Sprintf("Файл результата не найден: %s", filePath))
 		log.Println("⚠️ Ошибка: файл результата не существует")
-		return
+		return err
 	}
-
-	file, err := os.Open(filePath)
 	if err != nil {
 		log.Println("❌ Ошибка открытия файла результата:", err)
-		errMsg := fmt.Sprintf("Не удалось открыть файл результата: %v", err)
-		_, _ = stream.Write([]byte("ERROR\n" + errMsg + "\n"))
-		return
+		sendError(fmt.Sprintf("Не удалось открыть файл результата: %v", err))
+		return err
 	}
-	defer file.Close()
 
 	// Заголовок и передача данных
-	_, _ = stream.Write([]byte("IMAGE\n"))
-	if _, err := io.Copy(stream, file); err != nil {
+	if legacy {
+		err = writeResultV1(stream, jobID, fileName, data, "")
+	} else {
+		meta := map[string]string{MetaJob: jobID, MetaName: fileName}
+		putResultMeta(meta, res)
+		err = frame.Write(stream, frame.New(frame.TypeResult, meta, data))
+	}
+	if err != nil {
 		log.Println("❌ Ошибка отправки файла результата:", err)
 	}
+	return err
 }
@@ -0,0 +1,345 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// serverRetryAfter — сколько не пробовать сервер, который перестал отвечать, пока есть другие
+const serverRetryAfter = time.Minute
+
+// serverConnectTimeout ограничивает подключение к одному серверу списка
+const serverConnectTimeout = 10 * time.Second
+
+// reportRetry — как часто повторяются итоги заданий, которые не удалось доставить серверу
+const reportRetry = 15 * time.Second
+
+var (
+	// ErrServerUnavailable — сервер не ответил: соединение или поток оборвались. В отличие от
+	// отказа сервера (нет процессоров, не хватает токенов) это повод переключиться на другой сервер.
+	ErrServerUnavailable = errors.New("сервер не отвечает")
+	// ErrNoServer — ни один сервер из списка недоступен
+	ErrNoServer = errors.New("нет доступных серверов")
+	// ErrReportPending — сервер, зарезервировавший токены задания, не отвечает;
+	// итог отправляется повторно, пока он не вернётся
+	ErrReportPending = errors.New("итог задания отложен до возвращения сервера")
+)
+
+// serverState — исправность одного сервера списка
+type serverState struct {
+	info      peerstore.AddrInfo
+	failures  int       // неудач подряд
+	lastErr   error     // последняя неудача
+	downSince time.Time // когда сервер перестал отвечать; нулевое — исправен
+}
+
+// Servers — серверы узла в порядке предпочтения. Узел работает с одним текущим сервером;
+// если тот перестаёт отвечать, узел регистрируется на следующем доступном по списку.
+// Сервер, который не ответил, пропускается serverRetryAfter, пока есть другие.
+// Методы nil *Servers — узел без сервера (процессоры ищутся в DHT): итоги заданий
+// и heartbeat никуда не отправляются.
+//
+// У каждого сервера своя база токенов, серверы их не синхронизируют. Поэтому итог задания
+// отправляется серверу, который зарезервировал за него токены (doOn), а не текущему:
+// если тот не отвечает, итог повторяется каждые reportRetry, пока сервер не вернётся.
+type Servers struct {
+	host  host.Host
+	mode  string // роль узла при регистрации
+	token string // токен допуска для закрытого режима
+
+	dial sync.Mutex // переподключения выполняются по одному
+
+	mu       sync.Mutex
+	list     []*serverState  // состав списка не меняется, поля serverState — под mu
+	current  int             // индекс текущего сервера в list; -1 — не подключён
+	pending  []pendingReport // итоги, ждущие возвращения своего сервера
+	retrying bool            // запущен retryReports
+}
+
+// pendingReport — итог задания, который не удалось доставить серверу server
+type pendingReport struct {
+	server peerstore.ID
+	jobID  string
+	send   func(server peerstore.AddrInfo) error
+}
+
+// NewServers создаёт список серверов; подключение — Connect
+func NewServers(h host.Host, list []peerstore.AddrInfo, mode, token string) *Servers {
+	s := &Servers{host: h, mode: mode, token: token, current: -1}
+	for _, info := range list {
+		s.list = append(s.list, &serverState{info: info})
+	}
+	return s
+}
+
+// Connect подключается к первому доступному серверу списка и регистрируется на нём.
+// Если узел уже подключён (например, пока Connect ждал другое переподключение), возвращает текущий сервер.
+func (s *Servers) Connect() (peerstore.AddrInfo, error) {
+	if s == nil {
+		return peerstore.AddrInfo{}, ErrNoServer
+	}
+	s.dial.Lock()
+	defer s.dial.Unlock()
+	if info, ok := s.Current(); ok {
+		return info, nil
+	}
+	return s.connect()
+}
+
+// connect перебирает серверы по порядку: сначала те, что не падали недавно, затем
+// недавно упавшие. Порядок выбирается под mu, подключение и регистрация идут без него,
+// чтобы Current и Health не ждали сети. Вызывается под dial.
+func (s *Servers) connect() (peerstore.AddrInfo, error) {
+	s.mu.Lock()
+	s.current = -1
+	var fresh, recent []int
+	for i, st := range s.list {
+		if !st.downSince.IsZero() && time.Since(st.downSince) < serverRetryAfter {
+			recent = append(recent, i)
+		} else {
+			fresh = append(fresh, i)
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, i := range append(fresh, recent...) {
+		st := s.list[i]
+		err := s.register(st.info)
+		s.mu.Lock()
+		if err != nil {
+			s.markDown(st, err)
+			s.mu.Unlock()
+			errs = append(errs, fmt.Errorf("%s: %w", st.info.ID, err))
+			continue
+		}
+		st.failures, st.lastErr, st.downSince = 0, nil, time.Time{}
+		s.current = i
+		s.mu.Unlock()
+		fmt.Printf("✅ Подключен к серверу %s (%d из %d в списке)\n", st.info.ID, i+1, len(s.list))
+		return st.info, nil
+	}
+	return peerstore.AddrInfo{}, fmt.Errorf("%w: %w", ErrNoServer, errors.Join(errs...))
+}
+
+// register подключается к серверу и сообщает ему роль узла
+func (s *Servers) register(info peerstore.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverConnectTimeout)
+	defer cancel()
+	if err := s.host.Connect(ctx, info); err != nil {
+		return err
+	}
+	if err := Register(s.host, info, s.mode, s.token); err != nil {
+		return fmt.Errorf("регистрация: %w", err)
+	}
+	fmt.Println("📝 Зарегистрирован на сервере с ролью", s.mode)
+	return nil
+}
+
+// markDown учитывает неудачу сервера. Вызывается под mu.
+func (s *Servers) markDown(st *serverState, err error) {
+	st.failures++
+	st.lastErr = err
+	if st.downSince.IsZero() {
+		st.downSince = time.Now()
+	}
+}
+
+// Current возвращает текущий сервер; false — узел без сервера или все серверы недоступны
+func (s *Servers) Current() (peerstore.AddrInfo, bool) {
+	if s == nil {
+		return peerstore.AddrInfo{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current < 0 {
+		return peerstore.AddrInfo{}, false
+	}
+	return s.list[s.current].info, true
+}
+
+// failover отмечает, что сервер id не ответил, и, если он текущий, переключает узел
+// на следующий доступный сервер. Неудачи уже заменённого сервера только учитываются.
+func (s *Servers) failover(id peerstore.ID, err error) {
+	s.mu.Lock()
+	current := s.current >= 0 && s.list[s.current].info.ID == id
+	for _, st := range s.list {
+		if st.info.ID == id {
+			s.markDown(st, err)
+		}
+	}
+	if current {
+		s.current = -1 // остальные вызовы дождутся переподключения в Connect
+	}
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	log.Printf("🔀 Сервер %s не отвечает (%v), переключаемся на следующий\n", id, err)
+	if _, err := s.Connect(); err != nil {
+		log.Println("❌", err)
+	}
+}
+
+// do выполняет fn с текущим сервером. Если сервер не отвечает (ErrServerUnavailable),
+// узел переключается на следующий сервер и fn повторяется — не больше раза на сервер списка.
+func (s *Servers) do(fn func(server peerstore.AddrInfo) error) error {
+	if s == nil {
+		return ErrNoServer
+	}
+	var err error
+	for range s.list {
+		server, ok := s.Current()
+		if !ok {
+			// Все серверы не отвечали; возможно, какой-то уже вернулся
+			if server, err = s.Connect(); err != nil {
+				return err
+			}
+		}
+		err = fn(server)
+		if !errors.Is(err, ErrServerUnavailable) {
+			return err
+		}
+		s.failover(server.ID, err)
+	}
+	return err
+}
+
+// doOn выполняет fn с сервером id — тем, что зарезервировал токены задания jobID. Если сервер
+// не отвечает, fn не переносится на другой сервер (резерва там нет): вызов повторяется каждые
+// reportRetry, пока сервер не вернётся, а doOn возвращает ErrReportPending.
+// Пустой id или сервер не из списка — fn выполняется с текущим сервером, см. do.
+func (s *Servers) doOn(id peerstore.ID, jobID string, fn func(server peerstore.AddrInfo) error) error {
+	if s == nil {
+		return ErrNoServer
+	}
+	st := s.find(id)
+	if st == nil {
+		return s.do(fn)
+	}
+	err := s.send(st.info, fn)
+	if !errors.Is(err, ErrServerUnavailable) {
+		return err
+	}
+	s.failover(id, err)
+	s.mu.Lock()
+	s.pending = append(s.pending, pendingReport{server: id, jobID: jobID, send: fn})
+	if !s.retrying {
+		s.retrying = true
+		go s.retryReports()
+	}
+	s.mu.Unlock()
+	return fmt.Errorf("%w: %w", ErrReportPending, err)
+}
+
+// find возвращает сервер списка с ID id или nil
+func (s *Servers) find(id peerstore.ID) *serverState {
+	for _, st := range s.list {
+		if id != "" && st.info.ID == id {
+			return st
+		}
+	}
+	return nil
+}
+
+// send подключается к серверу info (он может быть не текущим) и выполняет fn
+func (s *Servers) send(info peerstore.AddrInfo, fn func(server peerstore.AddrInfo) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverConnectTimeout)
+	defer cancel()
+	if err := s.host.Connect(ctx, info); err != nil {
+		return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+	}
+	return fn(info)
+}
+
+// retryReports каждые reportRetry повторяет отложенные итоги, пока все они не доставлены.
+// Сервер, который не ответил, до следующего раза больше не пробуется.
+func (s *Servers) retryReports() {
+	for {
+		time.Sleep(reportRetry)
+		s.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+
+		var left []pendingReport
+		down := make(map[peerstore.ID]bool)
+		for _, r := range pending {
+			if down[r.server] {
+				left = append(left, r)
+				continue
+			}
+			err := s.send(s.find(r.server).info, r.send)
+			switch {
+			case errors.Is(err, ErrServerUnavailable):
+				down[r.server] = true
+				left = append(left, r)
+			case err != nil:
+				log.Printf("⚠️ Сервер %s не принял отложенный итог задания %s: %v\n", r.server, r.jobID, err)
+			default:
+				fmt.Printf("🪙 Отложенный итог задания %s доставлен серверу %s\n", r.jobID, r.server)
+			}
+		}
+
+		s.mu.Lock()
+		s.pending = append(left, s.pending...)
+		done := len(s.pending) == 0
+		if done {
+			s.retrying = false
+		}
+		s.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// WaitReports ждёт, пока отложенные итоги заданий будут доставлены, или отмены ctx.
+// Возвращает, сколько итогов так и не доставлено.
+func (s *Servers) WaitReports(ctx context.Context) int {
+	if s == nil {
+		return 0
+	}
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Health возвращает состояние серверов списка для вывода пользователю
+func (s *Servers) Health() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, len(s.list))
+	for i, st := range s.list {
+		state := "исправен"
+		switch {
+		case i == s.current:
+			state = "текущий"
+		case !st.downSince.IsZero():
+			state = fmt.Sprintf("не отвечает с %s (неудач подряд %d): %v", st.downSince.Format(time.TimeOnly), st.failures, st.lastErr)
+		}
+		lines[i] = fmt.Sprintf("%s — %s", st.info.ID, state)
+	}
+	return lines
+}
@@ -0,0 +1,60 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p/core/host"
+	peerstore "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newHost запускает узел на локальном адресе; он закрывается по окончании теста
+func newHost(t *testing.T) host.Host {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestReportPinnedToServer(t *testing.T) {
+	node, reserved, current := newHost(t), newHost(t), newHost(t)
+	s := NewServers(node, []peerstore.AddrInfo{
+		{ID: reserved.ID(), Addrs: reserved.Addrs()},
+		{ID: current.ID(), Addrs: current.Addrs()},
+	}, "initiator", "")
+	s.current = 1 // узел уже переключился на второй сервер
+
+	var got []peerstore.ID
+	send := func(server peerstore.AddrInfo) error {
+		got = append(got, server.ID)
+		return nil
+	}
+	if err := s.doOn(reserved.ID(), "job", send); err != nil || len(got) != 1 || got[0] != reserved.ID() {
+		t.Fatalf("итог отправлен %v (%v), ожидался сервер резерва %s", got, err, reserved.ID())
+	}
+	if err := s.doOn("", "job", send); err != nil || len(got) != 2 || got[1] != current.ID() {
+		t.Fatalf("итог без сервера резерва отправлен %v (%v), ожидался текущий %s", got, err, current.ID())
+	}
+
+	// Сервер резерва отключился: итог не переносится на текущий, а откладывается
+	reserved.Close()
+	node.Network().ClosePeer(reserved.ID())
+	if err := s.doOn(reserved.ID(), "job", send); !errors.Is(err, ErrReportPending) {
+		t.Fatalf("ошибка %v, ожидалась %v", err, ErrReportPending)
+	}
+	if len(got) != 2 {
+		t.Fatalf("итог отправлен другому серверу: %v", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) != 1 || s.pending[0].server != reserved.ID() || !s.retrying {
+		t.Fatalf("отложено %+v, ожидался итог для %s", s.pending, reserved.ID())
+	}
+	if s.current != 1 {
+		t.Fatalf("текущий сервер сменился на %d", s.current)
+	}
+}
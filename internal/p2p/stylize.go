@@ -0,0 +1,198 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"coursework_mimapr/internal/p2p/frame"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	network "github.com/libp2p/go-libp2p/core/network"
+)
+
+// ProgressInterval — как часто процессор подтверждает, что задание ещё выполняется
+const ProgressInterval = 10 * time.Second
+
+// progressTimeout — сколько инициатор ждёт очередной кадр, прежде чем считать связь потерянной
+const progressTimeout = 3 * ProgressInterval
+
+// defaultResultDir — каталог результатов для заданий без OutputDir
+const defaultResultDir = "processed_images"
+
+// Состояния задания в кадре TypeProgress
+const (
+	progressQueued  = "queued"
+	progressRunning = "running"
+)
+
+// Обработчик запроса стилизации по протоколу "/stylize/1.0.0".
+// Изображение и результат передаются в одном потоке: после подтверждения приёма процессор
+// каждые ProgressInterval пишет кадр TypeProgress, а затем результат или ошибку.
+// Если инициатор отключился, стилизация отменяется.
+func MakeStylizeHandler(p *Processor) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+
+		task, ok := p.acceptImage(s)
+		if !ok {
+			return
+		}
+		jobID := task.JobID
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan stylizeOutcome, 1)
+		var running atomic.Bool
+		accepted := p.Queue.Submit(func() {
+			if ctx.Err() != nil {
+				os.Remove(task.TmpIn)
+				done <- stylizeOutcome{err: ctx.Err()}
+				return
+			}
+			running.Store(true)
+			res, err := p.stylize(ctx, task)
+			done <- stylizeOutcome{res, err}
+		})
+		if !accepted {
+			os.Remove(task.TmpIn)
+			p.replyBusy(s, jobID)
+			return
+		}
+		if err := writeWithDeadline(s, frame.New(frame.TypeAck, map[string]string{MetaJob: jobID}, nil)); err != nil {
+			log.Println("❌ Ошибка подтверждения изображения:", err)
+			cancel()
+			discardResult(done)
+			p.report(task, StatusFailed, "инициатор отключился: "+err.Error())
+			return
+		}
+
+		started := time.Now()
+		ticker := time.NewTicker(ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case res := <-done:
+				defer os.Remove(res.Path)
+				p.replyOnStream(s, task, res)
+				return
+			case <-ticker.C:
+				state := progressQueued
+				if running.Load() {
+					state = progressRunning
+				}
+				meta := map[string]string{
+					MetaJob:     jobID,
+					MetaState:   state,
+					MetaElapsed: strconv.Itoa(int(time.Since(started).Seconds())),
+				}
+				if err := writeWithDeadline(s, frame.New(frame.TypeProgress, meta, nil)); err != nil {
+					log.Printf("⚠️ Инициатор задания %s отключился, стилизация отменена: %v\n", jobID, err)
+					cancel()
+					discardResult(done)
+					p.report(task, StatusFailed, "инициатор отключился: "+err.Error())
+					return
+				}
+			}
+		}
+	}
+}
+
+// replyOnStream пишет результат (или ошибку) в поток запроса и сообщает серверу итог.
+// Задание оплачивается только после подтверждения инициатора, что результат сохранён.
+func (p *Processor) replyOnStream(s network.Stream, task imageTask, res stylizeOutcome) {
+	jobID, fileName := task.JobID, task.FileName
+	if res.err != nil {
+		writeError(s, jobID, "Ошибка стилизации изображения")
+		p.report(task, StatusFailed, res.err.Error())
+		return
+	}
+	data, err := os.ReadFile(res.Path)
+	if err != nil {
+		log.Println("❌ Ошибка открытия файла результата:", err)
+		writeError(s, jobID, fmt.Sprintf("Не удалось открыть файл результата: %v", err))
+		p.report(task, StatusFailed, err.Error())
+		return
+	}
+	meta := map[string]string{MetaJob: jobID, MetaName: fileName}
+	putResultMeta(meta, res.ResultFile)
+	if err := writeWithDeadline(s, frame.New(frame.TypeResult, meta, data)); err != nil {
+		log.Println("❌ Ошибка отправки файла результата:", err)
+		p.report(task, StatusFailed, "результат не доставлен: "+err.Error())
+		return
+	}
+	s.SetReadDeadline(time.Now().Add(ackTimeout))
+	if _, err := frame.Expect(s, frame.TypeAck); err != nil {
+		log.Printf("⚠️ Инициатор не подтвердил результат задания %s: %v\n", jobID, err)
+		p.report(task, StatusFailed, "результат не подтверждён: "+err.Error())
+		return
+	}
+	fmt.Println("📤 Результат отправлен в поток запроса:", fileName, "задание", jobID)
+	p.reportDone(task)
+}
+
+// writeWithDeadline пишет кадр, не дожидаясь зависшего получателя дольше ackTimeout
+func writeWithDeadline(s network.Stream, f *frame.Frame) error {
+	s.SetWriteDeadline(time.Now().Add(ackTimeout))
+	return frame.Write(s, f)
+}
+
+// stylizeOutcome — итог стилизации из очереди процессора
+type stylizeOutcome struct {
+	ResultFile
+	err error
+}
+
+// discardResult дожидается отменённой стилизации в фоне и удаляет её результат
+func discardResult(done <-chan stylizeOutcome) {
+	go func() {
+		res := <-done
+		os.Remove(res.Path)
+	}()
+}
+
+// receiveStylizeResult читает из потока "/stylize/1.0.0" кадры прогресса и итог задания.
+// Поток закрывается по завершении. Если процессор замолчал дольше progressTimeout или
+// поток оборвался, попытка считается неудачной и инициатор повторит задание.
+func receiveStylizeResult(s network.Stream, jobs *JobTable, jobID string) {
+	defer s.Close()
+	from := s.Conn().RemotePeer()
+	lastState := ""
+	for {
+		s.SetReadDeadline(time.Now().Add(progressTimeout))
+		f, err := frame.Read(s)
+		if err != nil {
+			reason := fmt.Sprintf("связь с процессором %s потеряна: %v", from, err)
+			if jobs.AttemptFailed(jobID, from, reason) {
+				log.Printf("❌ Задание %s: %s\n", jobID, reason)
+			}
+			return
+		}
+		switch f.Type {
+		case frame.TypeProgress:
+			if state := f.Get(MetaState); state != lastState {
+				lastState = state
+				fmt.Printf("⏳ Задание %s у %s: %s (%s с)\n", jobID, from, state, f.Get(MetaElapsed))
+			}
+		case frame.TypeResult:
+			payload, env, err := openResult(jobs, from, jobID, f)
+			if err != nil {
+				failResult(jobs, from, jobID, err.Error())
+				return
+			}
+			if saveResult(jobs, defaultResultDir, from, jobID, f.Get(MetaName), readSizes(f), env, bytes.NewReader(payload)) {
+				_ = writeWithDeadline(s, frame.New(frame.TypeAck, map[string]string{MetaJob: jobID}, nil))
+			}
+			return
+		case frame.TypeError:
+			failResult(jobs, from, jobID, string(f.Payload))
+			return
+		default:
+			jobs.AttemptFailed(jobID, from, "неожиданный кадр "+f.Type.String())
+			return
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package sched выбирает процессор для нового задания по данным heartbeat.
+package sched
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StaleAfter — heartbeat старше этого считается устаревшим
+const StaleAfter = 45 * time.Second
+
+// defaultJobDuration используется, пока процессор не сообщил среднюю длительность
+const defaultJobDuration = 30 * time.Second
+
+// Load — загрузка процессора из последнего heartbeat
+type Load struct {
+	Queued      int           // заданий ждут в очереди
+	Depth       int           // максимальная глубина очереди
+	Active      int           // заданий стилизуется сейчас
+	Workers     int           // одновременных стилизаций
+	AvgDuration time.Duration // средняя длительность задания
+	CPUs        int
+	GPU         bool
+	Updated     time.Time
+}
+
+// Full сообщает, что процессор сейчас не примет задание
+func (l Load) Full() bool {
+	return l.Active >= max(l.Workers, 1) && l.Queued >= l.Depth
+}
+
+// Wait оценивает, через сколько новое задание начнёт выполняться
+func (l Load) Wait() time.Duration {
+	avg := l.AvgDuration
+	if avg == 0 {
+		avg = defaultJobDuration
+	}
+	busy := l.Queued + l.Active - max(l.Workers, 1) + 1
+	if busy <= 0 {
+		return 0
+	}
+	return avg * time.Duration(busy) / time.Duration(max(l.Workers, 1))
+}
+
+// Encode превращает загрузку в метаданные кадра heartbeat
+func (l Load) Encode() map[string]string {
+	gpu := "0"
+	if l.GPU {
+		gpu = "1"
+	}
+	return map[string]string{
+		"queued":  strconv.Itoa(l.Queued),
+		"depth":   strconv.Itoa(l.Depth),
+		"active":  strconv.Itoa(l.Active),
+		"workers": strconv.Itoa(l.Workers),
+		"avg_ms":  strconv.FormatInt(l.AvgDuration.Milliseconds(), 10),
+		"cpus":    strconv.Itoa(l.CPUs),
+		"gpu":     gpu,
+	}
+}
+
+// DecodeLoad разбирает метаданные heartbeat. Неизвестные поля остаются нулевыми.
+func DecodeLoad(meta map[string]string) Load {
+	num := func(key string) int {
+		v, _ := strconv.Atoi(meta[key])
+		return v
+	}
+	return Load{
+		Queued:      num("queued"),
+		Depth:       num("depth"),
+		Active:      num("active"),
+		Workers:     num("workers"),
+		AvgDuration: time.Duration(num("avg_ms")) * time.Millisecond,
+		CPUs:        num("cpus"),
+		GPU:         meta["gpu"] == "1",
+		Updated:     time.Now(),
+	}
+}
+
+// Candidate — процессор, которому можно назначить задание
+type Candidate struct {
+	ID   peer.ID
+	Load *Load // nil, если heartbeat ещё не приходил
+}
+
+// fresh возвращает загрузку, если она известна и не устарела
+func (c Candidate) fresh() (Load, bool) {
+	if c.Load == nil || time.Since(c.Load.Updated) > StaleAfter {
+		return Load{}, false
+	}
+	return *c.Load, true
+}
+
+// Strategy выбирает одного из кандидатов
+type Strategy interface {
+	Pick(candidates []Candidate) (peer.ID, bool)
+}
+
+// New возвращает стратегию по имени: "least-loaded" или "round-robin"
+func New(name string) (Strategy, error) {
+	switch name {
+	case "least-loaded", "":
+		return &LeastLoaded{}, nil
+	case "round-robin":
+		return &RoundRobin{}, nil
+	}
+	return nil, fmt.Errorf("неизвестная стратегия планирования %q", name)
+}
+
+// Assign выбирает процессор стратегией s и до следующего heartbeat считает назначенное
+// задание стоящим в его очереди, иначе все назначения между heartbeat уйдут одному процессору
+func Assign(s Strategy, candidates []Candidate) (peer.ID, bool) {
+	id, ok := s.Pick(candidates)
+	if !ok {
+		return "", false
+	}
+	for _, c := range candidates {
+		if c.ID == id && c.Load != nil {
+			c.Load.Queued++
+		}
+	}
+	return id, true
+}
+
+// RoundRobin назначает процессоры по кругу, не глядя на загрузку
+type RoundRobin struct {
+	next int
+}
+
+func (r *RoundRobin) Pick(candidates []Candidate) (peer.ID, bool) {
+	if len(candidates) == 0 {
+		return "", false
+	}
+	c := candidates[r.next%len(candidates)]
+	r.next++
+	return c.ID, true
+}
+
+// LeastLoaded выбирает процессор с наименьшим ожидаемым временем до начала задания.
+// Заполненные процессоры и процессоры без свежего heartbeat выбираются в последнюю очередь,
+// при равенстве предпочтение отдаётся GPU и большему числу ядер. Если свободных процессоров
+// со свежим heartbeat нет, процессоры без него назначаются по кругу: сравнивать их не по чему.
+type LeastLoaded struct {
+	unknown RoundRobin
+}
+
+func (l *LeastLoaded) Pick(candidates []Candidate) (peer.ID, bool) {
+	if len(candidates) == 0 {
+		return "", false
+	}
+	type scored struct {
+		id   peer.ID
+		rank int // 0 — свежий и свободный, 1 — неизвестный, 2 — заполненный
+		wait time.Duration
+		gpu  bool
+		cpus int
+	}
+	list := make([]scored, 0, len(candidates))
+	for _, c := range candidates {
+		s := scored{id: c.ID, rank: 1, wait: time.Duration(math.MaxInt64)}
+		if load, ok := c.fresh(); ok {
+			s.rank, s.wait, s.gpu, s.cpus = 0, load.Wait(), load.GPU, load.CPUs
+			if load.Full() {
+				s.rank = 2
+			}
+		}
+		list = append(list, s)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.wait != b.wait {
+			return a.wait < b.wait
+		}
+		if a.gpu != b.gpu {
+			return a.gpu
+		}
+		return a.cpus > b.cpus
+	})
+	if list[0].rank == 1 {
+		var unknown []Candidate
+		for _, s := range list {
+			if s.rank == 1 {
+				unknown = append(unknown, Candidate{ID: s.id})
+			}
+		}
+		return l.unknown.Pick(unknown)
+	}
+	return list[0].id, true
+}
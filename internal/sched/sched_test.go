@@ -0,0 +1,107 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// load — свежая загрузка процессора с одним обработчиком и очередью глубиной 4
+func load(queued, active int) *Load {
+	return &Load{Queued: queued, Depth: 4, Active: active, Workers: 1, AvgDuration: 10 * time.Second, Updated: time.Now()}
+}
+
+// pickN делает n назначений и возвращает выбранные процессоры по порядку
+func pickN(t *testing.T, s Strategy, candidates []Candidate, n int) []peer.ID {
+	t.Helper()
+	var picked []peer.ID
+	for i := 0; i < n; i++ {
+		id, ok := Assign(s, candidates)
+		if !ok {
+			t.Fatalf("назначение %d: процессор не выбран", i)
+		}
+		picked = append(picked, id)
+	}
+	return picked
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", "least-loaded", "round-robin"} {
+		if _, err := New(name); err != nil {
+			t.Errorf("New(%q): %v", name, err)
+		}
+	}
+	if _, err := New("random"); err == nil {
+		t.Error("неизвестная стратегия принята")
+	}
+}
+
+func TestEmptyCandidates(t *testing.T) {
+	for _, s := range []Strategy{&LeastLoaded{}, &RoundRobin{}} {
+		if _, ok := Assign(s, nil); ok {
+			t.Errorf("%T выбрала процессор из пустого списка", s)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	candidates := []Candidate{{ID: "a", Load: load(3, 1)}, {ID: "b"}, {ID: "c", Load: load(0, 0)}}
+	got := pickN(t, &RoundRobin{}, candidates, 6)
+	want := []peer.ID{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("назначения %v, ожидалось %v", got, want)
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	stale := load(0, 0)
+	stale.Updated = time.Now().Add(-2 * StaleAfter)
+	full := load(4, 1)
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		want       peer.ID
+	}{
+		{"меньше ожидание", []Candidate{{ID: "a", Load: load(2, 1)}, {ID: "b", Load: load(0, 1)}}, "b"},
+		{"свободный раньше неизвестного", []Candidate{{ID: "a"}, {ID: "b", Load: load(3, 1)}}, "b"},
+		{"устаревший как неизвестный", []Candidate{{ID: "a", Load: stale}, {ID: "b", Load: load(3, 1)}}, "b"},
+		{"неизвестный раньше заполненного", []Candidate{{ID: "a", Load: full}, {ID: "b"}}, "b"},
+		{"при равенстве GPU", []Candidate{{ID: "a", Load: load(0, 0)}, {ID: "b", Load: &Load{Depth: 4, Workers: 1, GPU: true, Updated: time.Now()}}}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := (&LeastLoaded{}).Pick(tt.candidates); got != tt.want {
+				t.Fatalf("выбран %s, ожидался %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeastLoadedCountsAssignments(t *testing.T) {
+	// Между heartbeat назначения учитываются в очереди, поэтому задания расходятся по процессорам
+	candidates := []Candidate{{ID: "a", Load: load(0, 0)}, {ID: "b", Load: load(0, 0)}}
+	got := pickN(t, &LeastLoaded{}, candidates, 4)
+	counts := map[peer.ID]int{}
+	for _, id := range got {
+		counts[id]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("назначения %v, ожидалось поровну", got)
+	}
+}
+
+func TestLeastLoadedWithoutHeartbeats(t *testing.T) {
+	// Без свежих heartbeat сравнивать процессоры не по чему: назначаем по кругу, а не всё первому
+	candidates := []Candidate{{ID: "a"}, {ID: "b"}, {ID: "c", Load: load(4, 1)}}
+	got := pickN(t, &LeastLoaded{}, candidates, 4)
+	want := []peer.ID{"a", "b", "a", "b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("назначения %v, ожидалось %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package seal шифрует изображения заданий, чтобы на дисках процессоров они лежали только
+// в зашифрованном виде. Изображение шифруется ключом задания (AES-256-GCM), который инициатор
+// передаёт процессору вместе с заданием, а результат процессор шифрует открытому ключу
+// инициатора (X25519 + HKDF-SHA256 + AES-256-GCM), поэтому сам расшифровать его уже не может.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeySize — длина ключа задания в байтах (AES-256)
+const KeySize = 32
+
+// hkdfInfo отделяет ключи результатов от других применений X25519
+const hkdfInfo = "coursework-result"
+
+// ErrCorrupted — данные повреждены, подменены или зашифрованы другим ключом
+var ErrCorrupted = errors.New("зашифрованные данные повреждены или ключ не подходит")
+
+// Keys — ключи одного задания на стороне инициатора
+type Keys struct {
+	Content []byte           // ключ, которым зашифровано изображение задания
+	Reply   *ecdh.PrivateKey // ключ, открытой части которого процессор шифрует результат
+}
+
+// NewKeys создаёт случайные ключи задания
+func NewKeys() (*Keys, error) {
+	content := make([]byte, KeySize)
+	if _, err := rand.Read(content); err != nil {
+		return nil, err
+	}
+	reply, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Keys{Content: content, Reply: reply}, nil
+}
+
+// Encrypt шифрует plaintext ключом key. Результат — nonce и шифротекст с тегом.
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt расшифровывает данные Encrypt
+func Decrypt(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCorrupted
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return plaintext, nil
+}
+
+// SealTo шифрует plaintext для владельца ключа to: одноразовый ключ X25519 и общий секрет
+// с to дают ключ AES. Результат — одноразовый открытый ключ и данные Encrypt.
+func SealTo(to *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sharedKey(eph, to)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := Encrypt(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(eph.PublicKey().Bytes(), sealed...), nil
+}
+
+// Open расшифровывает данные SealTo ключом получателя
+func Open(priv *ecdh.PrivateKey, sealed []byte) ([]byte, error) {
+	size := len(priv.PublicKey().Bytes())
+	if len(sealed) < size {
+		return nil, ErrCorrupted
+	}
+	eph, err := ecdh.X25519().NewPublicKey(sealed[:size])
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	key, err := sharedKey(priv, eph)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(key, sealed[size:])
+}
+
+// ParsePublicKey разбирает открытый ключ X25519, переданный инициатором
+func ParsePublicKey(raw []byte) (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// sharedKey выводит ключ AES из общего секрета X25519
+func sharedKey(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, secret, nil, hkdfInfo, KeySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("ключ задания должен быть %d байт, получено %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Tmpfs возвращает каталог в памяти, где расшифрованные данные существуют во время
+// стилизации: $TMPFS_DIR или /dev/shm. Если каталога нет, зашифрованные задания не принимаются.
+func Tmpfs() (string, error) {
+	dir := os.Getenv("TMPFS_DIR")
+	if dir == "" {
+		dir = "/dev/shm"
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("нет каталога в памяти для расшифрованных данных: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s не каталог", dir)
+	}
+	return dir, nil
+}
@@ -0,0 +1,125 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newKeys(t *testing.T) *Keys {
+	t.Helper()
+	keys, err := NewKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keys := newKeys(t)
+	for _, plaintext := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("pixels"), 10000)} {
+		sealed, err := Encrypt(keys.Content, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Короткий текст может случайно встретиться в шифротексте, проверяется только длинный
+		if len(plaintext) >= 16 && bytes.Contains(sealed, plaintext) {
+			t.Fatal("шифротекст содержит открытый текст")
+		}
+		got, err := Decrypt(keys.Content, sealed)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("расшифровано %d байт (%v), ожидалось %d", len(got), err, len(plaintext))
+		}
+	}
+}
+
+func TestEncryptUsesFreshNonce(t *testing.T) {
+	keys := newKeys(t)
+	a, _ := Encrypt(keys.Content, []byte("same"))
+	b, _ := Encrypt(keys.Content, []byte("same"))
+	if bytes.Equal(a, b) {
+		t.Fatal("одинаковый текст зашифрован одинаково")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	keys := newKeys(t)
+	sealed, err := Encrypt(keys.Content, []byte("image data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range sealed {
+		modified := bytes.Clone(sealed)
+		modified[i] ^= 0x01
+		if _, err := Decrypt(keys.Content, modified); !errors.Is(err, ErrCorrupted) {
+			t.Fatalf("изменённый байт %d: %v", i, err)
+		}
+	}
+	if _, err := Decrypt(keys.Content, sealed[:len(sealed)-1]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("обрезанный шифротекст: %v", err)
+	}
+	if _, err := Decrypt(keys.Content, sealed[:4]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("шифротекст короче nonce: %v", err)
+	}
+	if _, err := Decrypt(newKeys(t).Content, sealed); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("чужой ключ: %v", err)
+	}
+	if _, err := Decrypt(keys.Content[:16], sealed); err == nil {
+		t.Fatal("ключ неверной длины принят")
+	}
+}
+
+func TestSealToRoundTrip(t *testing.T) {
+	keys := newKeys(t)
+	result := []byte("stylized result")
+	sealed, err := SealTo(keys.Reply.PublicKey(), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Open(keys.Reply, sealed)
+	if err != nil || !bytes.Equal(got, result) {
+		t.Fatalf("открыто %q (%v)", got, err)
+	}
+
+	// Открытый ключ передаётся процессору в байтах и разбирается ParsePublicKey
+	pub, err := ParsePublicKey(keys.Reply.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed, err = SealTo(pub, result); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Open(keys.Reply, sealed); err != nil || !bytes.Equal(got, result) {
+		t.Fatalf("открыто %q (%v)", got, err)
+	}
+}
+
+func TestOpenWrongRecipient(t *testing.T) {
+	sealed, err := SealTo(newKeys(t).Reply.PublicKey(), []byte("stylized result"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(newKeys(t).Reply, sealed); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("результат открыт чужим ключом: %v", err)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	keys := newKeys(t)
+	sealed, err := SealTo(keys.Reply.PublicKey(), []byte("stylized result"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Изменение одноразового ключа, nonce, шифротекста или тега. Меняем младший бит:
+	// старший бит последнего байта ключа X25519 по RFC 7748 не используется.
+	for i := range sealed {
+		modified := bytes.Clone(sealed)
+		modified[i] ^= 0x01
+		if _, err := Open(keys.Reply, modified); err == nil {
+			t.Fatalf("изменённый байт %d принят", i)
+		}
+	}
+	if _, err := Open(keys.Reply, sealed[:10]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("обрезанные данные: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package style
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Params — параметры стилизации одного задания. Нулевое поле означает
+// значение по умолчанию процессора (DefaultParams).
+type Params struct {
+	Epochs int     `json:"epochs,omitempty" yaml:"epochs"` // шагов оптимизации
+	LR     float64 `json:"lr,omitempty" yaml:"lr"`         // скорость обучения Adam
+	Alpha  float64 `json:"alpha,omitempty" yaml:"alpha"`   // вес потерь содержимого
+	Beta   float64 `json:"beta,omitempty" yaml:"beta"`     // вес потерь стиля
+	Size   int     `json:"size,omitempty" yaml:"size"`     // сторона квадрата, к которому приводится изображение
+	// KeepAspect — стилизовать с сохранением пропорций (Size ограничивает длинную сторону)
+	// и вернуть результат в исходном разрешении
+	KeepAspect bool `json:"keep_aspect,omitempty" yaml:"keep_aspect"`
+}
+
+// DefaultParams — значения, которые раньше были зашиты в style_transfer.py
+var DefaultParams = Params{Epochs: 100, LR: 0.004, Alpha: 8, Beta: 70, Size: 512}
+
+// Or возвращает p, в котором нулевые поля заменены полями def
+func (p Params) Or(def Params) Params {
+	if p.Epochs == 0 {
+		p.Epochs = def.Epochs
+	}
+	if p.LR == 0 {
+		p.LR = def.LR
+	}
+	if p.Alpha == 0 {
+		p.Alpha = def.Alpha
+	}
+	if p.Beta == 0 {
+		p.Beta = def.Beta
+	}
+	if p.Size == 0 {
+		p.Size = def.Size
+	}
+	p.KeepAspect = p.KeepAspect || def.KeepAspect
+	return p
+}
+
+// Validate отклоняет отрицательные и нечисловые значения
+func (p Params) Validate() error {
+	if p.Epochs < 0 || p.Size < 0 {
+		return errors.New("параметры стилизации не могут быть отрицательными")
+	}
+	for _, x := range []float64{p.LR, p.Alpha, p.Beta} {
+		if !(x >= 0) || math.IsInf(x, 0) {
+			return errors.New("параметры стилизации должны быть неотрицательными числами")
+		}
+	}
+	return nil
+}
+
+// String перечисляет заданные поля, например "epochs=200 size=768".
+// Для нулевых Params возвращает пустую строку.
+func (p Params) String() string {
+	var parts []string
+	if p.Epochs != 0 {
+		parts = append(parts, "epochs="+strconv.Itoa(p.Epochs))
+	}
+	if p.LR != 0 {
+		parts = append(parts, "lr="+strconv.FormatFloat(p.LR, 'g', -1, 64))
+	}
+	if p.Alpha != 0 {
+		parts = append(parts, "alpha="+strconv.FormatFloat(p.Alpha, 'g', -1, 64))
+	}
+	if p.Beta != 0 {
+		parts = append(parts, "beta="+strconv.FormatFloat(p.Beta, 'g', -1, 64))
+	}
+	if p.Size != 0 {
+		parts = append(parts, "size="+strconv.Itoa(p.Size))
+	}
+	if p.KeepAspect {
+		parts = append(parts, "keep_aspect")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Limits — верхние границы параметров, которые устанавливает администратор процессора.
+// Нулевая граница не ограничивает.
+type Limits struct {
+	MaxEpochs int
+	MaxSize   int
+}
+
+// Check проверяет, что параметры (уже с подставленными значениями по умолчанию) не выходят за границы
+func (l Limits) Check(p Params) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if l.MaxEpochs > 0 && p.Epochs > l.MaxEpochs {
+		return fmt.Errorf("epochs=%d больше допустимого на процессоре (%d)", p.Epochs, l.MaxEpochs)
+	}
+	if l.MaxSize > 0 && p.Size > l.MaxSize {
+		return fmt.Errorf("size=%d больше допустимого на процессоре (%d)", p.Size, l.MaxSize)
+	}
+	return nil
+}
+
+// Result — размеры изображения при стилизации: рабочий (на котором шла оптимизация)
+// и итоговый (файл результата)
+type Result struct {
+	WorkWidth  int `json:"work_width"`
+	WorkHeight int `json:"work_height"`
+	Width      int `json:"width"`
+	Height     int `json:"height"`
+}
+
+// String возвращает размеры в виде "512x384 ➜ 2048x1536"
+func (r Result) String() string {
+	return fmt.Sprintf("%dx%d ➜ %dx%d", r.WorkWidth, r.WorkHeight, r.Width, r.Height)
+}
@@ -0,0 +1,66 @@
+package style
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParamsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		ok     bool
+	}{
+		{"по умолчанию", Params{}, true},
+		{"заданные", Params{Epochs: 200, LR: 0.01, Alpha: 1, Beta: 100, Size: 768, KeepAspect: true}, true},
+		{"отрицательные epochs", Params{Epochs: -1}, false},
+		{"отрицательный size", Params{Size: -512}, false},
+		{"отрицательный lr", Params{LR: -0.1}, false},
+		{"отрицательный alpha", Params{Alpha: -1}, false},
+		{"NaN", Params{LR: math.NaN()}, false},
+		{"+Inf", Params{Alpha: math.Inf(1)}, false},
+		{"-Inf", Params{Beta: math.Inf(-1)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.params.Validate(); (err == nil) != tt.ok {
+				t.Fatalf("Validate(%+v) = %v", tt.params, err)
+			}
+		})
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{MaxEpochs: 300, MaxSize: 1024}
+	tests := []struct {
+		name   string
+		limits Limits
+		params Params
+		ok     bool
+	}{
+		{"в пределах", limits, Params{Epochs: 300, Size: 1024}, true},
+		{"epochs больше", limits, Params{Epochs: 301, Size: 512}, false},
+		{"size больше", limits, Params{Epochs: 100, Size: 1025}, false},
+		{"ноль — без ограничения", Limits{}, Params{Epochs: 1 << 20, Size: 1 << 16}, true},
+		{"без ограничения epochs", Limits{MaxSize: 1024}, Params{Epochs: 1 << 20, Size: 1025}, false},
+		{"без ограничения size", Limits{MaxEpochs: 300}, Params{Epochs: 100, Size: 1 << 16}, true},
+		{"отрицательные при нулевых границах", Limits{}, Params{Epochs: -1}, false},
+		{"NaN в пределах границ", limits, Params{Epochs: 100, LR: math.NaN()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.Check(tt.params); (err == nil) != tt.ok {
+				t.Fatalf("%+v.Check(%+v) = %v", tt.limits, tt.params, err)
+			}
+		})
+	}
+}
+
+func TestParamsOr(t *testing.T) {
+	got := Params{Epochs: 10, KeepAspect: true}.Or(DefaultParams)
+	want := DefaultParams
+	want.Epochs, want.KeepAspect = 10, true
+	if got != want {
+		t.Fatalf("Or = %+v, ожидалось %+v", got, want)
+	}
+}
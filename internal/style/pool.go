@@ -0,0 +1,27 @@
+package style
+
+import "context"
+
+// Pool раздаёт стилизации нескольким исполнителям (например, нескольким воркерам):
+// задание получает первый свободный, остальные ждут
+type Pool struct {
+	free chan Stylizer
+}
+
+func NewPool(items ...Stylizer) *Pool {
+	p := &Pool{free: make(chan Stylizer, len(items))}
+	for _, s := range items {
+		p.free <- s
+	}
+	return p
+}
+
+func (p *Pool) Stylize(ctx context.Context, content, styleFile, output string, params Params) (Result, error) {
+	select {
+	case s := <-p.free:
+		defer func() { p.free <- s }()
+		return s.Stylize(ctx, content, styleFile, output, params)
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
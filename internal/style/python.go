@@ -15,7 +15,12 @@ func GetPythonCommand() string {
 
 // isImageFile проверяет расширение файла
 func IsImageFile(file fs.DirEntry) bool {
-	name := strings.ToLower(file.Name())
+	return IsImageName(file.Name())
+}
+
+// IsImageName проверяет расширение по имени файла
+func IsImageName(name string) bool {
+	name = strings.ToLower(name)
 	return strings.HasSuffix(name, ".jpg") ||
 		strings.HasSuffix(name, ".jpeg") ||
 		strings.HasSuffix(name, ".png")
@@ -0,0 +1,85 @@
+package style
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store хранит файлы признаков стиля под именем их SHA-256 хэша.
+// Один и тот же стиль от разных инициаторов хранится один раз и не перезаписывается.
+type Store struct {
+	Dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Hash возвращает SHA-256 данных в шестнадцатеричном виде
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile возвращает SHA-256 содержимого файла
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return Hash(data), nil
+}
+
+// ValidHash проверяет, что строка похожа на SHA-256 в hex
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Path возвращает путь к файлу стиля с указанным хэшем
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.Dir, hash+".pt")
+}
+
+// Has сообщает, есть ли стиль в хранилище
+func (s *Store) Has(hash string) bool {
+	if !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.Path(hash))
+	return err == nil
+}
+
+// Put сохраняет данные стиля и возвращает их хэш.
+// Запись идёт через временный файл, чтобы параллельные задания не увидели неполный стиль.
+func (s *Store) Put(data []byte) (string, error) {
+	hash := Hash(data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.Dir, "incoming_*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), s.Path(hash)); err != nil {
+		return "", fmt.Errorf("сохранение стиля %s: %w", hash, err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,348 @@
+package style
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Script — путь к Python-скрипту стилизации
+const Script = "style_transfer.py"
+
+// Stylizer стилизует одно изображение файлом признаков стиля с параметрами params
+// и возвращает размеры изображения при стилизации
+type Stylizer interface {
+	Stylize(ctx context.Context, content, styleFile, output string, params Params) (Result, error)
+}
+
+// Exec запускает отдельный процесс Python на каждое изображение.
+// Медленно (модель загружается каждый раз), но не требует долгоживущего процесса.
+type Exec struct {
+	Script string
+}
+
+// Размеры результата скрипт выводит JSON-строкой в stdout, остальной вывод — в stderr.
+func (e Exec) Stylize(ctx context.Context, content, styleFile, output string, params Params) (Result, error) {
+	args, err := json.Marshal(params)
+	if err != nil {
+		return Result{}, err
+	}
+	cmd := exec.CommandContext(ctx, GetPythonCommand(), e.Script, "stylize", content, styleFile, output, string(args))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Result{}, err
+	}
+	var res Result
+	if err := json.Unmarshal(out, &res); err != nil {
+		return Result{}, fmt.Errorf("неверный ответ скрипта стилизации: %w", err)
+	}
+	return res, nil
+}
+
+// ErrWorkerUnavailable — процесс воркера не запущен или перезапускается
+var ErrWorkerUnavailable = errors.New("воркер стилизации недоступен")
+
+const (
+	workerStartTimeout = 5 * time.Minute // загрузка VGG19 на CPU бывает долгой
+	workerStopTimeout  = 10 * time.Second
+	maxRestartBackoff  = 30 * time.Second
+	healthInterval     = 30 * time.Second
+	pingTimeout        = 10 * time.Second
+)
+
+// Worker держит один процесс "style_transfer.py serve" с загруженной моделью.
+// Запросы и ответы — JSON-строки через stdin/stdout. Упавший процесс перезапускается,
+// а пока его нет, запросы выполняет Fallback (если задан).
+type Worker struct {
+	Script   string
+	Fallback Stylizer
+
+	mu     sync.Mutex // один запрос за раз: воркер однопоточный
+	proc   *workerProc
+	device string
+	closed bool
+	seq    int
+}
+
+type workerProc struct {
+	cmd       *exec.Cmd
+	started   time.Time
+	stdin     io.WriteCloser
+	responses chan workerResponse
+	done      chan struct{} // закрывается после завершения процесса
+}
+
+type workerRequest struct {
+	ID      string  `json:"id"`
+	Op      string  `json:"op"`
+	Content string  `json:"content,omitempty"`
+	Style   string  `json:"style,omitempty"`
+	Output  string  `json:"output,omitempty"`
+	Params  *Params `json:"params,omitempty"`
+}
+
+type workerResponse struct {
+	ID     string `json:"id"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Device string `json:"device,omitempty"`
+	Result        // размеры изображения в ответе на stylize
+}
+
+func NewWorker(script string) *Worker {
+	return &Worker{Script: script, Fallback: Exec{Script: script}}
+}
+
+// Start запускает процесс воркера и ждёт, пока модель загрузится.
+// После успешного старта воркер перезапускается автоматически при падении.
+func (w *Worker) Start() error {
+	p, err := w.spawn()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.proc = p
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), workerStartTimeout)
+	defer cancel()
+	if err := w.Ping(ctx); err != nil {
+		w.mu.Lock()
+		w.proc = nil
+		w.mu.Unlock()
+		p.cmd.Process.Kill()
+		return fmt.Errorf("воркер не ответил при старте: %w", err)
+	}
+	go w.supervise(p)
+	go w.monitor()
+	return nil
+}
+
+// spawn запускает новый процесс воркера
+func (w *Worker) spawn() (*workerProc, error) {
+	cmd := exec.Command(GetPythonCommand(), w.Script, "serve")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &workerProc{
+		cmd:       cmd,
+		started:   time.Now(),
+		stdin:     stdin,
+		responses: make(chan workerResponse, 16),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			var resp workerResponse
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				log.Println("⚠️ Воркер прислал неверный ответ:", scanner.Text())
+				continue
+			}
+			select {
+			case p.responses <- resp:
+			default:
+				// Ответ на запрос, который уже никто не ждёт
+			}
+		}
+		// Wait можно вызывать только после того, как stdout прочитан до конца
+		cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// supervise перезапускает воркер после неожиданного завершения.
+// Если процесс падает сразу после старта, пауза между перезапусками растёт.
+func (w *Worker) supervise(p *workerProc) {
+	backoff := time.Second
+	for {
+		<-p.done
+
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		if w.proc == p {
+			w.proc = nil
+		}
+		w.mu.Unlock()
+
+		if time.Since(p.started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("⚠️ Воркер стилизации завершился (%v), перезапуск через %s\n", p.cmd.ProcessState, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxRestartBackoff)
+
+		next, err := w.spawn()
+		for err != nil {
+			log.Println("❌ Не удалось перезапустить воркер:", err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxRestartBackoff)
+			next, err = w.spawn()
+		}
+
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			next.cmd.Process.Kill()
+			return
+		}
+		w.proc = next
+		w.mu.Unlock()
+		p = next
+	}
+}
+
+// monitor периодически проверяет простаивающий воркер. Завис — процесс убивается
+// (callLocked делает это по таймауту), и supervise запускает новый.
+func (w *Worker) monitor() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Занятый воркер считаем живым: стилизация может идти долго
+		if !w.mu.TryLock() {
+			continue
+		}
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		if w.proc != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+			if _, err := w.callLocked(ctx, workerRequest{Op: "ping"}); err != nil {
+				log.Println("⚠️ Воркер стилизации не прошёл проверку:", err)
+			}
+			cancel()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Stylize выполняет стилизацию в воркере или через Fallback, если воркер недоступен
+func (w *Worker) Stylize(ctx context.Context, content, styleFile, output string, params Params) (Result, error) {
+	resp, err := w.call(ctx, workerRequest{Op: "stylize", Content: content, Style: styleFile, Output: output, Params: &params})
+	if errors.Is(err, ErrWorkerUnavailable) && w.Fallback != nil {
+		log.Println("⚠️ Воркер недоступен, стилизация отдельным процессом")
+		return w.Fallback.Stylize(ctx, content, styleFile, output, params)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if !resp.OK {
+		return Result{}, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// Ping проверяет, что воркер жив и отвечает на запросы
+func (w *Worker) Ping(ctx context.Context) error {
+	resp, err := w.call(ctx, workerRequest{Op: "ping"})
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	if resp.Device != "" {
+		w.mu.Lock()
+		w.device = resp.Device
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// Device возвращает устройство, на котором работает модель ("cpu" или "cuda")
+func (w *Worker) Device() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.device
+}
+
+// call отправляет запрос воркеру и ждёт ответа с тем же id.
+// Если ответа нет до отмены ctx, процесс убивается: поток ответов рассинхронизирован.
+func (w *Worker) call(ctx context.Context, req workerRequest) (workerResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.callLocked(ctx, req)
+}
+
+// callLocked — то же, что call, но w.mu уже захвачен вызывающим
+func (w *Worker) callLocked(ctx context.Context, req workerRequest) (workerResponse, error) {
+	p := w.proc
+	if p == nil {
+		return workerResponse{}, ErrWorkerUnavailable
+	}
+	w.seq++
+	req.ID = strconv.Itoa(w.seq)
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return workerResponse{}, err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return workerResponse{}, fmt.Errorf("%w: %v", ErrWorkerUnavailable, err)
+	}
+
+	for {
+		select {
+		case resp := <-p.responses:
+			if resp.ID != req.ID {
+				continue
+			}
+			return resp, nil
+		case <-p.done:
+			return workerResponse{}, errors.New("воркер стилизации завершился во время запроса")
+		case <-ctx.Done():
+			p.cmd.Process.Kill()
+			return workerResponse{}, ctx.Err()
+		}
+	}
+}
+
+// Close корректно останавливает воркер: дожидается текущего запроса, просит процесс
+// завершиться и убивает его, если он не завершился вовремя
+func (w *Worker) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	p := w.proc
+	w.proc = nil
+	w.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	line, _ := json.Marshal(workerRequest{Op: "shutdown"})
+	p.stdin.Write(append(line, '\n'))
+	p.stdin.Close()
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(workerStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+		return errors.New("воркер не завершился вовремя и был остановлен принудительно")
+	}
+}
@@ -0,0 +1,213 @@
+// Package tile делит большие изображения на перекрывающиеся фрагменты и
+// склеивает стилизованные фрагменты обратно с плавным переходом в зонах перекрытия.
+package tile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tile — один фрагмент изображения
+type Tile struct {
+	Index int
+	Rect  image.Rectangle // область фрагмента в исходном изображении
+}
+
+// Grid покрывает bounds квадратными фрагментами со стороной size, соседние фрагменты
+// перекрываются не меньше чем на overlap пикселей. Крайние фрагменты прижаты к краям,
+// поэтому все фрагменты (кроме изображений меньше size) одного размера.
+func Grid(bounds image.Rectangle, size, overlap int) []Tile {
+	xs := positions(bounds.Dx(), size, overlap)
+	ys := positions(bounds.Dy(), size, overlap)
+	tiles := make([]Tile, 0, len(xs)*len(ys))
+	for _, y := range ys {
+		for _, x := range xs {
+			r := image.Rect(x, y, min(x+size, bounds.Dx()), min(y+size, bounds.Dy())).Add(bounds.Min)
+			tiles = append(tiles, Tile{Index: len(tiles), Rect: r})
+		}
+	}
+	return tiles
+}
+
+// positions возвращает начала фрагментов вдоль одной стороны длиной n
+func positions(n, size, overlap int) []int {
+	if n <= size {
+		return []int{0}
+	}
+	step := max(size-overlap, 1)
+	var ps []int
+	for p := 0; p+size < n; p += step {
+		ps = append(ps, p)
+	}
+	return append(ps, n-size)
+}
+
+// Needed сообщает, что изображение размером w x h нужно делить на фрагменты со стороной size
+func Needed(w, h, size int) bool {
+	return size > 0 && (w > size || h > size)
+}
+
+// Split сохраняет фрагменты img в dir в формате PNG (без потерь) и возвращает пути к файлам
+func Split(img image.Image, tiles []Tile, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(tiles))
+	for i, t := range tiles {
+		sub := image.NewNRGBA(image.Rect(0, 0, t.Rect.Dx(), t.Rect.Dy()))
+		draw.Draw(sub, sub.Bounds(), img, t.Rect.Min, draw.Src)
+		paths[i] = filepath.Join(dir, fmt.Sprintf("tile_%03d.png", t.Index))
+		if err := Save(paths[i], sub); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// Blend склеивает стилизованные фрагменты в изображение размером bounds. Вес пикселя фрагмента
+// линейно спадает к его внутренним краям на ширине overlap, поэтому швов не видно.
+// Фрагмент, вернувшийся другого размера, масштабируется к своей области.
+func Blend(bounds image.Rectangle, tiles []Tile, results []image.Image, overlap int) *image.NRGBA {
+	w, h := bounds.Dx(), bounds.Dy()
+	sum := make([]float32, w*h*3)
+	weights := make([]float32, w*h)
+	for i, t := range tiles {
+		res := results[i]
+		tw, th := t.Rect.Dx(), t.Rect.Dy()
+		// Зоны перекрытия есть только у краёв, граничащих с другими фрагментами
+		left, right := t.Rect.Min.X > bounds.Min.X, t.Rect.Max.X < bounds.Max.X
+		top, bottom := t.Rect.Min.Y > bounds.Min.Y, t.Rect.Max.Y < bounds.Max.Y
+		for y := 0; y < th; y++ {
+			wy := ramp(y, th, overlap, top, bottom)
+			for x := 0; x < tw; x++ {
+				wgt := wy * ramp(x, tw, overlap, left, right)
+				if wgt == 0 {
+					continue
+				}
+				r, g, b := sample(res, (float64(x)+0.5)/float64(tw), (float64(y)+0.5)/float64(th))
+				idx := (t.Rect.Min.Y-bounds.Min.Y+y)*w + t.Rect.Min.X - bounds.Min.X + x
+				sum[idx*3] += wgt * r
+				sum[idx*3+1] += wgt * g
+				sum[idx*3+2] += wgt * b
+				weights[idx] += wgt
+			}
+		}
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, wgt := range weights {
+		if wgt == 0 {
+			continue
+		}
+		out.Pix[i*4] = clamp8(sum[i*3] / wgt)
+		out.Pix[i*4+1] = clamp8(sum[i*3+1] / wgt)
+		out.Pix[i*4+2] = clamp8(sum[i*3+2] / wgt)
+		out.Pix[i*4+3] = 0xff
+	}
+	return out
+}
+
+// ramp — вес позиции p на отрезке длиной n: у внутренних краёв линейно растёт
+// от 0 до 1 на ширине overlap, у краёв изображения равен 1
+func ramp(p, n, overlap int, fromStart, fromEnd bool) float32 {
+	if overlap <= 0 {
+		return 1
+	}
+	wgt := float32(1)
+	if fromStart {
+		wgt = min(wgt, (float32(p)+0.5)/float32(overlap))
+	}
+	if fromEnd {
+		wgt = min(wgt, (float32(n-p)-0.5)/float32(overlap))
+	}
+	return wgt
+}
+
+// sample возвращает цвет img в точке (u, v) ∈ [0, 1]² с билинейной интерполяцией
+func sample(img image.Image, u, v float64) (r, g, b float32) {
+	bounds := img.Bounds()
+	fx := u*float64(bounds.Dx()) - 0.5
+	fy := v*float64(bounds.Dy()) - 0.5
+	x0, y0 := clampInt(int(fx), bounds.Dx()-1), clampInt(int(fy), bounds.Dy()-1)
+	x1, y1 := clampInt(x0+1, bounds.Dx()-1), clampInt(y0+1, bounds.Dy()-1)
+	ax, ay := float32(max(fx-float64(x0), 0)), float32(max(fy-float64(y0), 0))
+	ax, ay = min(ax, 1), min(ay, 1)
+
+	at := func(x, y int) (float32, float32, float32) {
+		c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+		return float32(c.R), float32(c.G), float32(c.B)
+	}
+	r00, g00, b00 := at(x0, y0)
+	r10, g10, b10 := at(x1, y0)
+	r01, g01, b01 := at(x0, y1)
+	r11, g11, b11 := at(x1, y1)
+	lerp := func(a, b, t float32) float32 { return a + (b-a)*t }
+	r = lerp(lerp(r00, r10, ax), lerp(r01, r11, ax), ay)
+	g = lerp(lerp(g00, g10, ax), lerp(g01, g11, ax), ay)
+	b = lerp(lerp(b00, b10, ax), lerp(b01, b11, ax), ay)
+	return r, g, b
+}
+
+func clampInt(v, hi int) int {
+	return max(0, min(v, hi))
+}
+
+func clamp8(v float32) uint8 {
+	return uint8(max(0, min(v+0.5, 255)))
+}
+
+// Load читает изображение PNG или JPEG
+func Load(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("чтение %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// Size возвращает размеры изображения, не декодируя его целиком
+func Size(path string) (w, h int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("чтение %s: %w", path, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Save сохраняет изображение в формате по расширению path: JPEG для .jpg/.jpeg, иначе PNG
+func Save(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".jpg" || ext == ".jpeg" {
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
+	} else {
+		err = png.Encode(file, img)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
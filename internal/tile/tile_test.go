@@ -0,0 +1,170 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestGridCoversBounds(t *testing.T) {
+	tests := []struct {
+		name          string
+		bounds        image.Rectangle
+		size, overlap int
+	}{
+		{"меньше фрагмента", image.Rect(0, 0, 300, 200), 512, 64},
+		{"ровно фрагмент", image.Rect(0, 0, 512, 512), 512, 64},
+		{"чуть больше", image.Rect(0, 0, 513, 512), 512, 64},
+		{"несколько рядов", image.Rect(0, 0, 1920, 1080), 512, 64},
+		{"большое перекрытие", image.Rect(0, 0, 1000, 700), 256, 200},
+		{"без перекрытия", image.Rect(0, 0, 1000, 700), 256, 0},
+		{"перекрытие больше фрагмента", image.Rect(0, 0, 100, 40), 16, 32},
+		{"смещённые границы", image.Rect(-50, 30, 900, 610), 300, 48},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiles := Grid(tt.bounds, tt.size, tt.overlap)
+			covered := make([]int, tt.bounds.Dx()*tt.bounds.Dy())
+			for i, tile := range tiles {
+				if tile.Index != i {
+					t.Fatalf("фрагмент %d с номером %d", i, tile.Index)
+				}
+				if !tile.Rect.In(tt.bounds) || tile.Rect.Empty() {
+					t.Fatalf("фрагмент %v вне %v", tile.Rect, tt.bounds)
+				}
+				if want := min(tt.size, tt.bounds.Dx()); tile.Rect.Dx() != want {
+					t.Fatalf("ширина фрагмента %v — %d, ожидалось %d", tile.Rect, tile.Rect.Dx(), want)
+				}
+				if want := min(tt.size, tt.bounds.Dy()); tile.Rect.Dy() != want {
+					t.Fatalf("высота фрагмента %v — %d, ожидалось %d", tile.Rect, tile.Rect.Dy(), want)
+				}
+				for y := tile.Rect.Min.Y; y < tile.Rect.Max.Y; y++ {
+					for x := tile.Rect.Min.X; x < tile.Rect.Max.X; x++ {
+						covered[(y-tt.bounds.Min.Y)*tt.bounds.Dx()+x-tt.bounds.Min.X]++
+					}
+				}
+			}
+			for i, n := range covered {
+				if n == 0 {
+					t.Fatalf("пиксель (%d, %d) не покрыт", i%tt.bounds.Dx(), i/tt.bounds.Dx())
+				}
+			}
+			checkOverlap(t, positions(tt.bounds.Dx(), tt.size, tt.overlap), tt.bounds.Dx(), tt.size, tt.overlap)
+			checkOverlap(t, positions(tt.bounds.Dy(), tt.size, tt.overlap), tt.bounds.Dy(), tt.size, tt.overlap)
+		})
+	}
+}
+
+// checkOverlap проверяет, что соседние фрагменты вдоль стороны длиной n идут по возрастанию
+// и перекрываются не меньше чем на overlap пикселей (но не больше, чем позволяет size)
+func checkOverlap(t *testing.T, ps []int, n, size, overlap int) {
+	t.Helper()
+	if ps[0] != 0 {
+		t.Fatalf("первый фрагмент начинается с %d", ps[0])
+	}
+	if last := ps[len(ps)-1]; n > size && last != n-size {
+		t.Fatalf("последний фрагмент начинается с %d, ожидалось %d", last, n-size)
+	}
+	want := min(overlap, size-1)
+	for i := 1; i < len(ps); i++ {
+		if ps[i] <= ps[i-1] {
+			t.Fatalf("начала фрагментов не возрастают: %v", ps)
+		}
+		if got := ps[i-1] + size - ps[i]; got < want {
+			t.Fatalf("фрагменты %d и %d перекрываются на %d, нужно не меньше %d (%v)", i-1, i, got, want, ps)
+		}
+	}
+}
+
+// noise — изображение со случайными непрозрачными пикселями
+func noise(bounds image.Rectangle) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(bounds)
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+// crop копирует область r изображения img в новое изображение с началом в (0, 0)
+func crop(img image.Image, r image.Rectangle) *image.NRGBA {
+	sub := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(sub, sub.Bounds(), img, r.Min, draw.Src)
+	return sub
+}
+
+func TestBlendIdentityTiles(t *testing.T) {
+	for _, bounds := range []image.Rectangle{
+		image.Rect(0, 0, 200, 130),
+		image.Rect(0, 0, 64, 64),
+		image.Rect(10, -20, 150, 90),
+	} {
+		for _, overlap := range []int{0, 8, 24} {
+			src := noise(bounds)
+			tiles := Grid(bounds, 64, overlap)
+			results := make([]image.Image, len(tiles))
+			for i, tile := range tiles {
+				results[i] = crop(src, tile.Rect)
+			}
+			out := Blend(bounds, tiles, results, overlap)
+			if want := crop(src, bounds); !equalPixels(out, want) {
+				t.Fatalf("%v, перекрытие %d: склеенное изображение отличается от исходного", bounds, overlap)
+			}
+		}
+	}
+}
+
+func TestBlendResizedTiles(t *testing.T) {
+	// Фрагмент, вернувшийся в другом размере, масштабируется к своей области
+	bounds := image.Rect(0, 0, 120, 80)
+	tiles := Grid(bounds, 64, 16)
+	fill := color.NRGBA{R: 200, G: 40, B: 90, A: 0xff}
+	results := make([]image.Image, len(tiles))
+	for i := range tiles {
+		img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+		draw.Draw(img, img.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+		results[i] = img
+	}
+	out := Blend(bounds, tiles, results, 16)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if c := out.NRGBAAt(x, y); c != fill {
+				t.Fatalf("пиксель (%d, %d) = %v, ожидался %v", x, y, c, fill)
+			}
+		}
+	}
+}
+
+func equalPixels(a, b *image.NRGBA) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			if a.NRGBAAt(a.Rect.Min.X+x, a.Rect.Min.Y+y) != b.NRGBAAt(b.Rect.Min.X+x, b.Rect.Min.Y+y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestNeeded(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+		want       bool
+	}{
+		{100, 100, 0, false},
+		{100, 100, 100, false},
+		{101, 100, 100, true},
+		{100, 101, 100, true},
+	}
+	for _, tt := range tests {
+		if got := Needed(tt.w, tt.h, tt.size); got != tt.want {
+			t.Errorf("Needed(%d, %d, %d) = %v", tt.w, tt.h, tt.size, got)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package verify сравнивает результаты стилизации одного задания, полученные от разных
+// процессоров, и выбирает результат большинства.
+package verify
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// side — сторона уменьшенной копии изображения, на которой считается сходство.
+// Стилизация недетерминирована, поэтому сравниваются структура и яркость, а не пиксели.
+const side = 64
+
+// window — сторона окна, по которому считается локальная SSIM
+const window = 8
+
+// Константы SSIM для яркости в диапазоне [0, 255]
+const (
+	c1 = (0.01 * 255) * (0.01 * 255)
+	c2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// Similarity возвращает сходство изображений от 0 до 1 (1 — совпадают): среднюю SSIM
+// по окнам 8x8 их уменьшенных до 64x64 полутоновых копий. Размеры изображений могут отличаться.
+func Similarity(a, b image.Image) float64 {
+	return similarity(gray(a), gray(b))
+}
+
+// similarity — Similarity для уже уменьшенных копий
+func similarity(ga, gb []float64) float64 {
+	var sum float64
+	n := 0
+	for y := 0; y+window <= side; y += window / 2 {
+		for x := 0; x+window <= side; x += window / 2 {
+			sum += ssim(ga, gb, x, y)
+			n++
+		}
+	}
+	return max(0, sum/float64(n))
+}
+
+// ssim — структурное сходство окна с левым верхним углом (x0, y0)
+func ssim(a, b []float64, x0, y0 int) float64 {
+	var ma, mb float64
+	for y := y0; y < y0+window; y++ {
+		for x := x0; x < x0+window; x++ {
+			ma += a[y*side+x]
+			mb += b[y*side+x]
+		}
+	}
+	const n = window * window
+	ma, mb = ma/n, mb/n
+	var va, vb, cov float64
+	for y := y0; y < y0+window; y++ {
+		for x := x0; x < x0+window; x++ {
+			da, db := a[y*side+x]-ma, b[y*side+x]-mb
+			va += da * da
+			vb += db * db
+			cov += da * db
+		}
+	}
+	va, vb, cov = va/(n-1), vb/(n-1), cov/(n-1)
+	return (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+}
+
+// gray уменьшает img до side x side усреднением по областям и переводит в яркость
+func gray(img image.Image) []float64 {
+	bounds := img.Bounds()
+	out := make([]float64, side*side)
+	for y := 0; y < side; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/side
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/side, y0+1)
+		for x := 0; x < side; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/side
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/side, x0+1)
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					sum += float64(color.GrayModel.Convert(img.At(px, py)).(color.Gray).Y)
+				}
+			}
+			out[y*side+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// Majority ищет результат, с которым согласно большинство: для каждого изображения считает,
+// со сколькими другими его сходство не меньше threshold, и выбирает изображение с наибольшим
+// числом согласных (при равенстве — с наибольшим суммарным сходством). Возвращает его индекс
+// и индексы всех изображений, согласных с ним (включая его самого). Решение о кворуме
+// принимает вызывающий по len(agree). Для пустого imgs возвращает -1.
+func Majority(imgs []image.Image, threshold float64) (winner int, agree []int) {
+	n := len(imgs)
+	if n == 0 {
+		return -1, nil
+	}
+	sim := make([][]float64, n)
+	for i := range sim {
+		sim[i] = make([]float64, n)
+		sim[i][i] = 1
+	}
+	grays := make([][]float64, n)
+	for i, img := range imgs {
+		grays[i] = gray(img)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			sim[i][j] = similarity(grays[i], grays[j])
+			sim[j][i] = sim[i][j]
+		}
+	}
+
+	winner, best, bestSum := 0, -1, math.Inf(-1)
+	for i := 0; i < n; i++ {
+		votes, total := 0, 0.0
+		for j := 0; j < n; j++ {
+			if sim[i][j] >= threshold {
+				votes++
+			}
+			total += sim[i][j]
+		}
+		if votes > best || (votes == best && total > bestSum) {
+			winner, best, bestSum = i, votes, total
+		}
+	}
+	for j := 0; j < n; j++ {
+		if sim[winner][j] >= threshold {
+			agree = append(agree, j)
+		}
+	}
+	return winner, agree
+}
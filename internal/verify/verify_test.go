@@ -0,0 +1,108 @@
+package verify
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// threshold — порог сходства по умолчанию у инициатора (--similarity)
+const threshold = 0.85
+
+// pattern рисует изображение w x h: fn получает координаты в долях стороны и возвращает яркость
+func pattern(w, h int, fn func(x, y float64) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: fn(float64(x)/float64(w), float64(y)/float64(h))})
+		}
+	}
+	return img
+}
+
+// stripes — вертикальные полосы, rings — концентрические окружности: структура у них разная
+func stripes(w, h int) *image.Gray {
+	return pattern(w, h, func(x, _ float64) uint8 {
+		if int(x*16)%2 == 0 {
+			return 30
+		}
+		return 220
+	})
+}
+
+func rings(w, h int) *image.Gray {
+	return pattern(w, h, func(x, y float64) uint8 {
+		d := (x-0.5)*(x-0.5) + (y-0.5)*(y-0.5)
+		if int(d*200)%2 == 0 {
+			return 220
+		}
+		return 30
+	})
+}
+
+// noisy — img со слабым шумом: так отличаются результаты недетерминированной стилизации
+func noisy(img *image.Gray, seed int64) *image.Gray {
+	rng := rand.New(rand.NewSource(seed))
+	out := image.NewGray(img.Bounds())
+	for i, v := range img.Pix {
+		out.Pix[i] = uint8(min(255, max(0, int(v)+rng.Intn(11)-5)))
+	}
+	return out
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  image.Image
+		agree bool
+	}{
+		{"одно изображение", stripes(256, 256), stripes(256, 256), true},
+		{"слабый шум", stripes(256, 256), noisy(stripes(256, 256), 1), true},
+		{"разные размеры", stripes(512, 384), stripes(256, 192), true},
+		{"разная структура", stripes(256, 256), rings(256, 256), false},
+		{"разная структура и размеры", stripes(300, 200), rings(128, 128), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Similarity(tt.a, tt.b)
+			if s < 0 || s > 1.0000001 {
+				t.Fatalf("сходство %f вне [0, 1]", s)
+			}
+			if (s >= threshold) != tt.agree {
+				t.Fatalf("сходство %f, порог %f", s, threshold)
+			}
+		})
+	}
+}
+
+func TestMajority(t *testing.T) {
+	tests := []struct {
+		name    string
+		imgs    []image.Image
+		winners []int // допустимые победители
+		agree   int   // сколько результатов согласны с победителем
+	}{
+		{"все согласны", []image.Image{stripes(256, 256), noisy(stripes(256, 256), 1), noisy(stripes(256, 256), 2)}, []int{0, 1, 2}, 3},
+		{"меньшинство не согласно", []image.Image{rings(256, 256), stripes(256, 256), noisy(stripes(256, 256), 3)}, []int{1, 2}, 2},
+		{"разные размеры", []image.Image{stripes(640, 480), rings(256, 256), stripes(320, 240)}, []int{0, 2}, 2},
+		{"все разные", []image.Image{stripes(256, 256), rings(256, 256)}, []int{0, 1}, 1},
+		{"ничья", []image.Image{stripes(256, 256), noisy(stripes(256, 256), 4), rings(256, 256), noisy(rings(256, 256), 5)}, []int{0, 1, 2, 3}, 2},
+		{"один результат", []image.Image{rings(64, 64)}, []int{0}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winner, agree := Majority(tt.imgs, threshold)
+			if !slices.Contains(tt.winners, winner) {
+				t.Fatalf("победитель %d, ожидался один из %v", winner, tt.winners)
+			}
+			if len(agree) != tt.agree || !slices.Contains(agree, winner) {
+				t.Fatalf("согласны %v с %d, ожидалось %d", agree, winner, tt.agree)
+			}
+		})
+	}
+	if winner, agree := Majority(nil, threshold); winner != -1 || agree != nil {
+		t.Fatalf("пустой список: %d, %v", winner, agree)
+	}
+}
@@ -7,24 +7,43 @@ from torchvision.models import vgg19, VGG19_Weights
 from PIL import Image
 import sys
 import os
+import json
 
 device = 'cuda' if torch.cuda.is_available() else 'cpu'
 
-# Загрузка и преобразование изображения
-def load_image(path):
+# Параметры стилизации по умолчанию; задание может переопределить любой из них.
+# keep_aspect: стилизовать с сохранением пропорций (size ограничивает длинную сторону)
+# и вернуть результат в исходном разрешении; иначе изображение приводится к size x size.
+DEFAULT_PARAMS = {"epochs": 100, "lr": 0.004, "alpha": 8.0, "beta": 70.0, "size": 512, "keep_aspect": False}
+
+# Меньше этого VGG после всех пулингов не на чем считать признаки
+MIN_SIDE = 32
+
+# Рабочий размер (ширина, высота): длинная сторона не больше bound, пропорции сохраняются
+def working_size(width, height, bound):
+    scale = min(1.0, bound / max(width, height))
+    return max(MIN_SIDE, round(width * scale)), max(MIN_SIDE, round(height * scale))
+
+# Загрузка и преобразование изображения. Возвращает тензор и исходный размер (ширина, высота).
+def load_image(path, size=512, keep_aspect=False):
+    image = Image.open(path).convert("RGB")
+    original = image.size
+    width, height = working_size(*original, size) if keep_aspect else (size, size)
     transform = transforms.Compose([
-        transforms.Resize((512, 512)),
+        transforms.Resize((height, width)),
         transforms.ToTensor()
     ])
-    image = Image.open(path).convert("RGB")
     image = transform(image).unsqueeze(0)
-    return image.to(device)
+    return image.to(device), original
 
-# Сохранение изображения
-def save_output(tensor, path):
+# Сохранение изображения; при заданном size результат масштабируется до него
+def save_output(tensor, path, size=None):
     image = tensor.clone().detach().cpu().squeeze(0)
     image = transforms.ToPILImage()(image)
+    if size is not None and image.size != tuple(size):
+        image = image.resize(size, Image.LANCZOS)
     image.save(path)
+    return image.size
 
 # Модель VGG для извлечения признаков
 class VGG(nn.Module):
@@ -64,7 +83,7 @@ def calculate_total_loss(gf, cf, sf, alpha=8, beta=70):
 def extract_style(style_image_path, out_tensor_path):
     try:
         model = VGG().to(device).eval()
-        image = load_image(style_image_path)
+        image, _ = load_image(style_image_path)
         features = model(image)
         torch.save(features, out_tensor_path)
         print(f"✅ Признаки стиля сохранены в {out_tensor_path}")
@@ -79,22 +98,33 @@ def extract_style(style_image_path, out_tensor_path):
         sys.exit(1)
 
 
-# Применение стиля по признакам
-def apply_style(content_path, style_tensor_path, output_path):
-    model = VGG().to(device).eval()
-    content = load_image(content_path)
+# Параметры задания поверх значений по умолчанию (пустые и нулевые значения игнорируются)
+def resolve_params(params):
+    resolved = dict(DEFAULT_PARAMS)
+    for key, value in (params or {}).items():
+        if key in resolved and value:
+            resolved[key] = type(DEFAULT_PARAMS[key])(value)
+    return resolved
+
+
+# Стилизация изображения уже загруженной моделью. Ошибки пробрасываются вызывающему.
+# Возвращает рабочий и итоговый размеры изображения.
+def stylize(model, content_path, style_tensor_path, output_path, params=None):
+    params = resolve_params(params)
+    content, original = load_image(content_path, params["size"], params["keep_aspect"])
+    work_height, work_width = content.shape[2], content.shape[3]
     style_feat = torch.load(style_tensor_path, weights_only=False)
     generated = content.clone().requires_grad_(True)
 
-    optimizer = optim.Adam([generated], lr=0.004)
-    epochs = 100
+    optimizer = optim.Adam([generated], lr=params["lr"])
+    epochs = params["epochs"]
 
     try:
         for i in range(epochs):
             gen_feat = model(generated)
             cont_feat = model(content)
 
-            loss = calculate_total_loss(gen_feat, cont_feat, style_feat)
+            loss = calculate_total_loss(gen_feat, cont_feat, style_feat, params["alpha"], params["beta"])
 
             optimizer.zero_grad()
             loss.backward()
@@ -104,12 +134,11 @@ def apply_style(content_path, style_tensor_path, output_path):
                 print(f"[{i}/{epochs}] Loss: {loss.item():.4f}")
 
         # Сохраняем только если всё прошло без exception
-        save_output(generated, output_path)
-        print(f"✅ Стилизация завершена. Сохранено в {output_path}")
+        width, height = save_output(generated, output_path, original if params["keep_aspect"] else None)
+        print(f"✅ Стилизация завершена ({work_width}x{work_height} ➜ {width}x{height}). Сохранено в {output_path}")
+        return {"work_width": work_width, "work_height": work_height, "width": width, "height": height}
 
-    except Exception as e:
-        # Логируем ошибку
-        print(f"❌ Ошибка во время стилизации: {e}", file=sys.stderr)
+    except Exception:
         # Удаляем потенциально частично записанный файл
         if os.path.exists(output_path):
             try:
@@ -117,14 +146,73 @@ def apply_style(content_path, style_tensor_path, output_path):
                 print(f"🗑 Удалён неполный файл: {output_path}")
             except OSError as rmErr:
                 print(f"⚠️ Не удалось удалить {output_path}: {rmErr}", file=sys.stderr)
-        # Завершаем с ошибкой
+        raise
+
+
+# Применение стиля по признакам (однократный запуск). Размеры результата выводятся
+# JSON-строкой в stdout, весь остальной вывод перенаправляется в stderr.
+def apply_style(content_path, style_tensor_path, output_path, params=None):
+    proto = sys.stdout
+    sys.stdout = sys.stderr
+    model = VGG().to(device).eval()
+    try:
+        result = stylize(model, content_path, style_tensor_path, output_path, params)
+        proto.write(json.dumps(result) + "\n")
+        proto.flush()
+    except Exception as e:
+        # Логируем ошибку и завершаем с ошибкой
+        print(f"❌ Ошибка во время стилизации: {e}", file=sys.stderr)
         sys.exit(1)
+
+
+# Долгоживущий процесс: модель загружается один раз, запросы приходят JSON-строками в stdin,
+# ответы уходят JSON-строками в stdout. Весь остальной вывод перенаправляется в stderr.
+def serve():
+    proto = sys.stdout
+    sys.stdout = sys.stderr
+
+    def reply(msg):
+        proto.write(json.dumps(msg) + "\n")
+        proto.flush()
+
+    model = VGG().to(device).eval()
+    print(f"🐍 Воркер стилизации готов ({device})")
+
+    for line in sys.stdin:
+        line = line.strip()
+        if not line:
+            continue
+        try:
+            req = json.loads(line)
+        except ValueError as e:
+            reply({"id": "", "ok": False, "error": f"неверный запрос: {e}"})
+            continue
+
+        op = req.get("op")
+        req_id = req.get("id", "")
+        if op == "ping":
+            reply({"id": req_id, "ok": True, "device": device})
+        elif op == "shutdown":
+            reply({"id": req_id, "ok": True})
+            break
+        elif op == "stylize":
+            try:
+                result = stylize(model, req["content"], req["style"], req["output"], req.get("params"))
+                reply({"id": req_id, "ok": True, **result})
+            except Exception as e:
+                print(f"❌ Ошибка во время стилизации: {e}", file=sys.stderr)
+                reply({"id": req_id, "ok": False, "error": str(e)})
+        else:
+            reply({"id": req_id, "ok": False, "error": f"неизвестная операция: {op}"})
+
+
 # CLI
 if __name__ == "__main__":
     if len(sys.argv) < 2:
         print("Использование:\n"
               "  extract-style <style.jpg> <style.pt>\n"
-              "  stylize <content.jpg> <style.pt> <output.jpg>")
+              "  stylize <content.jpg> <style.pt> <output.jpg> [параметры JSON]\n"
+              "  serve")
         sys.exit(1)
 
     command = sys.argv[1]
@@ -132,8 +220,12 @@ if __name__ == "__main__":
     if command == "extract-style" and len(sys.argv) == 4:
         extract_style(sys.argv[2], sys.argv[3])
 
-    elif command == "stylize" and len(sys.argv) == 5:
-        apply_style(sys.argv[2], sys.argv[3], sys.argv[4])
+    elif command == "stylize" and len(sys.argv) in (5, 6):
+        params = json.loads(sys.argv[5]) if len(sys.argv) == 6 else None
+        apply_style(sys.argv[2], sys.argv[3], sys.argv[4], params)
+
+    elif command == "serve" and len(sys.argv) == 2:
+        serve()
 
     else:
         print("❌ Неверные аргументы.")